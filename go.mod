@@ -6,10 +6,16 @@ require (
 	github.com/aws/aws-lambda-go v1.35.0
 	github.com/ipthomas/tukcnst v1.3.3
 	github.com/ipthomas/tukpdq v1.3.4
+	github.com/ipthomas/tukutil v1.3.3
 )
 
 require (
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/ipthomas/tukhttp v1.3.4 // indirect
-	github.com/ipthomas/tukutil v1.3.3 // indirect; indirects
+)
+
+replace (
+	github.com/ipthomas/tukcnst => ./lib/tukcnst
+	github.com/ipthomas/tukhttp => ./lib/tukhttp
+	github.com/ipthomas/tukpdq => ./lib/tukpdq
 )
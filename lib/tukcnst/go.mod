@@ -0,0 +1,3 @@
+module github.com/ipthomas/tukcnst
+
+go 1.19
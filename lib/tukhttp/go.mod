@@ -0,0 +1,12 @@
+module github.com/ipthomas/tukhttp
+
+go 1.19
+
+require (
+	github.com/ipthomas/tukcnst v1.3.3
+	github.com/ipthomas/tukutil v1.3.3
+)
+
+require github.com/google/uuid v1.3.0 // indirect
+
+replace github.com/ipthomas/tukcnst => ../tukcnst
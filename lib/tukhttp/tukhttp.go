@@ -0,0 +1,479 @@
+package tukhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipthomas/tukcnst"
+	"github.com/ipthomas/tukutil"
+)
+
+var DebugMode = false
+
+type CGLRequest struct {
+	Request    string
+	X_Api_Key  string
+	Timeout    int64
+	StatusCode int
+	Response   []byte
+}
+type PIXmRequest struct {
+	URL        string
+	PID_OID    string
+	PID        string
+	Timeout    int64
+	StatusCode int
+	Response   []byte
+}
+type PIXmOpRequest struct {
+	URL           string
+	PID_OID       string
+	PID           string
+	TargetSystems []string
+	Timeout       int64
+	StatusCode    int
+	Response      []byte
+}
+type FHIRRequest struct {
+	Method     string
+	URL        string
+	Body       []byte
+	Timeout    int64
+	StatusCode int
+	Response   []byte
+}
+type SOAPRequest struct {
+	URL        string
+	SOAPAction string
+	Timeout    int64
+	StatusCode int
+	Body       []byte
+	Response   []byte
+}
+type AWS_APIRequest struct {
+	URL        string
+	Act        string
+	Resource   string
+	Timeout    int64
+	StatusCode int
+	Body       []byte
+	Response   []byte
+}
+
+// DynamoDBRequest sends a DynamoDB API Action (eg GetItem) to the DynamoDB endpoint URL. The request is signed with AWS Signature Version 4 using the AWS Env credentials provided to the Lambda.
+// Set URL to a DynamoDB Local endpoint (eg http://localhost:8000) for testing
+type DynamoDBRequest struct {
+	URL        string
+	Region     string
+	Action     string
+	Timeout    int64
+	StatusCode int
+	Body       []byte
+	Response   []byte
+}
+type ClientRequest struct {
+	HttpRequest  *http.Request
+	ServerURL    string `json:"serverurl"`
+	Act          string `json:"act"`
+	User         string `json:"user"`
+	Org          string `json:"org"`
+	Orgoid       string `json:"orgoid"`
+	Role         string `json:"role"`
+	NHS          string `json:"nhs"`
+	PID          string `json:"pid"`
+	PIDOrg       string `json:"pidorg"`
+	PIDOID       string `json:"pidoid"`
+	FamilyName   string `json:"familyname"`
+	GivenName    string `json:"givenname"`
+	DOB          string `json:"dob"`
+	Gender       string `json:"gender"`
+	ZIP          string `json:"zip"`
+	Status       string `json:"status"`
+	XDWKey       string `json:"xdwkey"`
+	ID           int    `json:"id"`
+	Task         string `json:"task"`
+	Pathway      string `json:"pathway"`
+	Version      int    `json:"version"`
+	ReturnFormat string `json:"returnformat"`
+}
+type TukHTTPInterface interface {
+	newRequest(ctx context.Context) error
+}
+
+func NewRequest(i TukHTTPInterface) error {
+	return NewRequestWithContext(context.Background(), i)
+}
+
+// NewRequestWithContext sends the request with ctx as the parent of the request timeout context, so the request is cancelled if ctx is cancelled or reaches its deadline before the request Timeout
+func NewRequestWithContext(ctx context.Context, i TukHTTPInterface) error {
+	return i.newRequest(ctx)
+}
+
+// NewURL returns the base url with path, if not empty, joined to the url path and params added to the url query. Params replace any query parameters of the same name in base.
+// Path and params are escaped so values such as patient ids cannot change the url path or add query parameters. A path of . or .. is rejected as it would be resolved to a different resource
+func NewURL(base string, path string, params url.Values) (string, error) {
+	if path == "." || path == ".." {
+		return "", fmt.Errorf("%s is not a valid url path segment", path)
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	if path != "" {
+		u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + url.PathEscape(path)
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path
+	}
+	if len(params) > 0 {
+		query := u.Query()
+		for key, vals := range params {
+			query[key] = vals
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
+func (i *ClientRequest) newRequest(ctx context.Context) error {
+	req := i.HttpRequest
+	req.ParseForm()
+	i.Act = req.FormValue(tukcnst.ACT)
+	i.User = req.FormValue("user")
+	i.Org = req.FormValue("org")
+	i.Orgoid = tukutil.GetCodeSystemVal(req.FormValue("org"))
+	i.Role = req.FormValue("role")
+	i.NHS = req.FormValue("nhs")
+	i.PID = req.FormValue("pid")
+	i.PIDOrg = req.FormValue("pidorg")
+	i.PIDOID = tukutil.GetCodeSystemVal(req.FormValue("pidorg"))
+	i.FamilyName = req.FormValue("familyname")
+	i.GivenName = req.FormValue("givenname")
+	i.DOB = req.FormValue("dob")
+	i.Gender = req.FormValue("gender")
+	i.ZIP = req.FormValue("zip")
+	i.Status = req.FormValue("status")
+	i.ID = tukutil.GetIntFromString(req.FormValue("id"))
+	i.Task = req.FormValue(tukcnst.TASK)
+	i.Pathway = req.FormValue(tukcnst.PATHWAY)
+	i.Version = tukutil.GetIntFromString(req.FormValue("version"))
+	i.XDWKey = req.FormValue("xdwkey")
+	i.ReturnFormat = req.Header.Get(tukcnst.ACCEPT)
+	if len(i.XDWKey) > 12 {
+		i.Pathway, i.NHS = tukutil.SplitXDWKey(i.XDWKey)
+	}
+	return nil
+}
+func (i *SOAPRequest) newRequest(ctx context.Context) error {
+	if i.Timeout == 0 {
+		i.Timeout = 15
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(i.Timeout)*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, i.URL, strings.NewReader(string(i.Body)))
+	if err != nil {
+		return err
+	}
+	if i.SOAPAction != "" {
+		req.Header.Set(tukcnst.SOAP_ACTION, i.SOAPAction)
+	}
+	req.Header.Set(tukcnst.CONTENT_TYPE, tukcnst.SOAP_XML)
+	req.Header.Set(tukcnst.ACCEPT, tukcnst.ALL)
+	req.Header.Set(tukcnst.CONNECTION, tukcnst.KEEP_ALIVE)
+	i.logRequest(req.Header)
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	i.StatusCode = resp.StatusCode
+	i.Response, err = io.ReadAll(resp.Body)
+	i.logResponse()
+	return err
+}
+func (i *PIXmRequest) newRequest(ctx context.Context) error {
+	var err error
+	var req *http.Request
+	if i.Timeout == 0 {
+		i.Timeout = 15
+	}
+	if i.URL, err = NewURL(i.URL, "", url.Values{"identifier": {i.PID_OID + "|" + i.PID}, "_format": {tukcnst.JSON}, "_pretty": {"true"}}); err != nil {
+		return err
+	}
+	if req, err = http.NewRequest(tukcnst.HTTP_GET, i.URL, nil); err == nil {
+		req.Header.Set(tukcnst.CONTENT_TYPE, tukcnst.APPLICATION_JSON)
+		req.Header.Set(tukcnst.ACCEPT, tukcnst.ALL)
+		req.Header.Set(tukcnst.CONNECTION, tukcnst.KEEP_ALIVE)
+		i.logRequest(req.Header)
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(i.Timeout)*time.Second)
+		defer cancel()
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		i.StatusCode = resp.StatusCode
+		if i.Response, err = io.ReadAll(resp.Body); err != nil {
+			l(err.Error(), false)
+		}
+		defer resp.Body.Close()
+		i.logResponse()
+		return nil
+	}
+	return err
+}
+
+// newRequest performs an IHE ITI-83 PIXm $ihe-pix operation. URL is the PIXm server Patient endpoint and TargetSystems are optional target domain oids
+func (i *PIXmOpRequest) newRequest(ctx context.Context) error {
+	if i.Timeout == 0 {
+		i.Timeout = 15
+	}
+	params := url.Values{}
+	params.Set("sourceIdentifier", tukcnst.URN_OID_PREFIX+i.PID_OID+"|"+i.PID)
+	for _, targetSystem := range i.TargetSystems {
+		params.Add("targetSystem", tukcnst.URN_OID_PREFIX+targetSystem)
+	}
+	params.Set("_format", tukcnst.JSON)
+	var err error
+	if i.URL, err = NewURL(i.URL, "$ihe-pix", params); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(tukcnst.HTTP_GET, i.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(tukcnst.ACCEPT, tukcnst.APPLICATION_FHIR_JSON)
+	req.Header.Set(tukcnst.CONNECTION, tukcnst.KEEP_ALIVE)
+	i.logRequest(req.Header)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(i.Timeout)*time.Second)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	i.StatusCode = resp.StatusCode
+	i.Response, err = io.ReadAll(resp.Body)
+	i.logResponse()
+	return err
+}
+
+// newRequest performs a FHIR json request. URL must include any query parameters. Method defaults to GET
+func (i *FHIRRequest) newRequest(ctx context.Context) error {
+	if i.Timeout == 0 {
+		i.Timeout = 15
+	}
+	if i.Method == "" {
+		i.Method = tukcnst.HTTP_GET
+	}
+	req, err := http.NewRequest(i.Method, i.URL, bytes.NewReader(i.Body))
+	if err != nil {
+		return err
+	}
+	if len(i.Body) > 0 {
+		req.Header.Set(tukcnst.CONTENT_TYPE, tukcnst.APPLICATION_FHIR_JSON)
+	}
+	req.Header.Set(tukcnst.ACCEPT, tukcnst.APPLICATION_FHIR_JSON)
+	req.Header.Set(tukcnst.CONNECTION, tukcnst.KEEP_ALIVE)
+	i.logRequest(req.Header)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(i.Timeout)*time.Second)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	i.StatusCode = resp.StatusCode
+	i.Response, err = io.ReadAll(resp.Body)
+	i.logResponse()
+	return err
+}
+func (i *CGLRequest) newRequest(ctx context.Context) error {
+	req, err := http.NewRequest(tukcnst.HTTP_GET, i.Request, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(tukcnst.ACCEPT, tukcnst.APPLICATION_JSON)
+	req.Header.Set("X-API-KEY", i.X_Api_Key)
+	if i.Timeout == 0 {
+		i.Timeout = 5
+	}
+	i.logRequest(req.Header)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(i.Timeout)*time.Second)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	i.StatusCode = resp.StatusCode
+	i.Response, err = io.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	i.logResponse()
+	return err
+}
+func (i *AWS_APIRequest) newRequest(ctx context.Context) error {
+	if i.Timeout == 0 {
+		i.Timeout = 5
+	}
+	var err error
+	var req *http.Request
+	var resp *http.Response
+	client := &http.Client{}
+	if req, err = http.NewRequest(http.MethodPost, i.URL+i.Resource, bytes.NewBuffer(i.Body)); err == nil {
+		req.Header.Add(tukcnst.CONTENT_TYPE, tukcnst.APPLICATION_JSON_CHARSET_UTF_8)
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(i.Timeout)*time.Second)
+		defer cancel()
+		i.logRequest(req.Header)
+		if resp, err = client.Do(req.WithContext(ctx)); err == nil {
+			if resp.StatusCode == http.StatusOK {
+				i.Response, err = io.ReadAll(resp.Body)
+			}
+		}
+	}
+	defer resp.Body.Close()
+	i.StatusCode = resp.StatusCode
+	i.logResponse()
+	return err
+}
+func (i *DynamoDBRequest) newRequest(ctx context.Context) error {
+	if i.Timeout == 0 {
+		i.Timeout = 5
+	}
+	req, err := http.NewRequest(http.MethodPost, i.URL, bytes.NewReader(i.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(tukcnst.CONTENT_TYPE, tukcnst.APPLICATION_X_AMZ_JSON)
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+i.Action)
+	signRequest(req, i.Body, i.Region, "dynamodb", time.Now().UTC())
+	i.logRequest(req.Header)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(i.Timeout)*time.Second)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	i.StatusCode = resp.StatusCode
+	i.Response, err = io.ReadAll(resp.Body)
+	i.logResponse()
+	return err
+}
+
+// signRequest sets the X-Amz-Date, X-Amz-Security-Token and AWS Signature Version 4 Authorization headers for the request to the AWS service in region. All the request headers are signed
+func signRequest(req *http.Request, body []byte, region string, service string, now time.Time) {
+	amzdate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+	req.Header.Set("X-Amz-Date", amzdate)
+	if token := os.Getenv(tukcnst.ENV_AWS_SESSION_TOKEN); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	names := []string{"host"}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	sort.Strings(names)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := req.Method + "\n" + path + "\n" + req.URL.RawQuery + "\n"
+	for _, name := range names {
+		canonical = canonical + name + ":" + headers[name] + "\n"
+	}
+	canonical = canonical + "\n" + strings.Join(names, ";") + "\n" + sha256Hex(body)
+	stringtosign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := []byte("AWS4" + os.Getenv(tukcnst.ENV_AWS_SECRET_ACCESS_KEY))
+	for _, part := range []string{now.Format("20060102"), region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x", os.Getenv(tukcnst.ENV_AWS_ACCESS_KEY_ID), scope, strings.Join(names, ";"), hmacSHA256(key, stringtosign)))
+}
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+func (i *DynamoDBRequest) logRequest(headers http.Header) {
+	l(fmt.Sprintf("DynamoDB Request\nURL = %s\nAction = %s\nTimeout = %v", i.URL, i.Action, i.Timeout), true)
+}
+func (i *DynamoDBRequest) logResponse() {
+	l(fmt.Sprintf("DynamoDB Response - Status Code = %v", i.StatusCode), true)
+}
+func (i *AWS_APIRequest) logRequest(headers http.Header) {
+	l("HTTP POST Request Headers", true)
+	tukutil.Log(headers)
+	l(fmt.Sprintf("HTTP Request\nURL = %s\nTimeout = %v\nMessage body\n%s", i.URL, i.Timeout, string(i.Body)), true)
+}
+func (i *AWS_APIRequest) logResponse() {
+	l(fmt.Sprintf("HTML Response - Status Code = %v\n%s", i.StatusCode, string(i.Response)), true)
+}
+func (i *SOAPRequest) logRequest(headers http.Header) {
+	l("SOAP Request Headers", true)
+	tukutil.Log(headers)
+	l(fmt.Sprintf("SOAP Request\nURL = %s\nAction = %s\nTimeout = %v\n\n%s", i.URL, i.SOAPAction, i.Timeout, string(i.Body)), true)
+}
+func (i *SOAPRequest) logResponse() {
+	l(fmt.Sprintf("SOAP Response - Status Code = %v\n%s", i.StatusCode, string(i.Response)), true)
+}
+func (i *PIXmRequest) logRequest(headers http.Header) {
+	l("HTTP GET Request Headers", true)
+	tukutil.Log(headers)
+	l(fmt.Sprintf("HTTP Request\nURL = %s\nTimeout = %v", i.URL, i.Timeout), true)
+}
+func (i *PIXmOpRequest) logRequest(headers http.Header) {
+	l("HTTP GET Request Headers", true)
+	tukutil.Log(headers)
+	l(fmt.Sprintf("HTTP Request\nURL = %s\nTimeout = %v", i.URL, i.Timeout), true)
+}
+func (i *PIXmOpRequest) logResponse() {
+	l(fmt.Sprintf("HTML Response - Status Code = %v\n%s", i.StatusCode, string(i.Response)), true)
+}
+func (i *FHIRRequest) logRequest(headers http.Header) {
+	l(fmt.Sprintf("HTTP %s Request Headers", i.Method), true)
+	tukutil.Log(headers)
+	l(fmt.Sprintf("HTTP Request\nURL = %s\nTimeout = %v\n%s", i.URL, i.Timeout, string(i.Body)), true)
+}
+func (i *FHIRRequest) logResponse() {
+	l(fmt.Sprintf("HTML Response - Status Code = %v\n%s", i.StatusCode, string(i.Response)), true)
+}
+func (i *CGLRequest) logRequest(headers http.Header) {
+	l("HTTP GET Request Headers", true)
+	// the CGL api key is never written to the logs
+	redacted := headers.Clone()
+	if redacted.Get("X-API-KEY") != "" {
+		redacted.Set("X-API-KEY", "REDACTED")
+	}
+	tukutil.Log(redacted)
+	l(fmt.Sprintf("HTTP Request\nURL = %s - Timeout = %v", i.Request, i.Timeout), true)
+}
+func (i *PIXmRequest) logResponse() {
+	l(fmt.Sprintf("HTML Response - Status Code = %v\n%s", i.StatusCode, string(i.Response)), true)
+}
+func (i *CGLRequest) logResponse() {
+	l(fmt.Sprintf("HTML Response - Status Code = %v\n%s", i.StatusCode, string(i.Response)), true)
+}
+func l(msg string, debug bool) {
+	if !debug {
+		log.Println(msg)
+	} else {
+		if DebugMode {
+			log.Println(msg)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package tukhttp
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+func TestNewURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		path    string
+		params  url.Values
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "query param",
+			base:   "https://pix.example.org/r4/Patient",
+			params: url.Values{"identifier": {"urn:oid:1.2.3|9999999468"}},
+			want:   "https://pix.example.org/r4/Patient?identifier=urn%3Aoid%3A1.2.3%7C9999999468",
+		},
+		{
+			name:   "hostile query value cannot add params",
+			base:   "https://pix.example.org/r4/Patient",
+			params: url.Values{"identifier": {"1.2.3|1&_format=xml#frag"}},
+			want:   "https://pix.example.org/r4/Patient?identifier=1.2.3%7C1%26_format%3Dxml%23frag",
+		},
+		{
+			name:   "params replace base params of the same name and keep others",
+			base:   "https://pix.example.org/r4/Patient?_format=xml&tenant=a",
+			params: url.Values{"_format": {"json"}},
+			want:   "https://pix.example.org/r4/Patient?_format=json&tenant=a",
+		},
+		{
+			name: "path segment",
+			base: "https://pix.example.org/r4/Patient/",
+			path: "$ihe-pix",
+			want: "https://pix.example.org/r4/Patient/$ihe-pix",
+		},
+		{
+			name:   "path segment keeps base query",
+			base:   "https://cgl.example.org/api/v1/user?tenant=a",
+			path:   "9999999468",
+			params: url.Values{"_format": {"json"}},
+			want:   "https://cgl.example.org/api/v1/user/9999999468?_format=json&tenant=a",
+		},
+		{
+			name: "hostile path segment is escaped",
+			base: "https://cgl.example.org/api/v1/user",
+			path: "../admin?x=1#y",
+			want: "https://cgl.example.org/api/v1/user/..%2Fadmin%3Fx=1%23y",
+		},
+		{
+			name:    "dot segment is rejected",
+			base:    "https://cgl.example.org/api/v1/user",
+			path:    ".",
+			wantErr: true,
+		},
+		{
+			name:    "dot dot segment is rejected",
+			base:    "https://cgl.example.org/api/v1/user",
+			path:    "..",
+			wantErr: true,
+		},
+		{
+			name:    "invalid base url",
+			base:    "http://[::1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := NewURL(tt.base, tt.path, tt.params)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: NewURL = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestSignRequest checks the AWS Signature Version 4 Authorization header against the get-vanilla, post-vanilla and post-x-www-form-urlencoded requests of the AWS Signature Version 4 test suite
+func TestSignRequest(t *testing.T) {
+	t.Setenv(tukcnst.ENV_AWS_ACCESS_KEY_ID, "AKIDEXAMPLE")
+	t.Setenv(tukcnst.ENV_AWS_SECRET_ACCESS_KEY, "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv(tukcnst.ENV_AWS_SESSION_TOKEN, "")
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		method      string
+		contenttype string
+		body        string
+		want        string
+	}{
+		{"get-vanilla", http.MethodGet, "", "", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"post-vanilla", http.MethodPost, "", "", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"post-x-www-form-urlencoded", http.MethodPost, "application/x-www-form-urlencoded", "Param1=value1", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "https://example.amazonaws.com/", strings.NewReader(tt.body))
+		if tt.contenttype != "" {
+			req.Header.Set(tukcnst.CONTENT_TYPE, tt.contenttype)
+		}
+		signRequest(req, []byte(tt.body), "us-east-1", "service", now)
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("%s: X-Amz-Date = %q, want 20150830T123600Z", tt.name, got)
+		}
+		if got := req.Header.Get("Authorization"); got != tt.want {
+			t.Errorf("%s: Authorization = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCGLRequestDoesNotLogApiKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-KEY") != "secret-api-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	DebugMode = true
+	defer func() { DebugMode = false }()
+	req := CGLRequest{Request: srv.URL, X_Api_Key: "secret-api-key"}
+	if err := NewRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %v, want %v - the api key was not sent", req.StatusCode, http.StatusOK)
+	}
+	if strings.Contains(logged.String(), "secret-api-key") {
+		t.Errorf("api key was logged\n%s", logged.String())
+	}
+	if !strings.Contains(logged.String(), "REDACTED") {
+		t.Errorf("request headers were not logged\n%s", logged.String())
+	}
+}
+
+func TestPIXmRequestsEscapeHostileIdentifiers(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	pid := "9999999468&_format=xml&identifier=1.2.3|EVIL#x"
+	tests := []struct {
+		name  string
+		req   TukHTTPInterface
+		path  string
+		param string
+		want  string
+	}{
+		{"pixm", &PIXmRequest{URL: srv.URL + "/r4/Patient", PID_OID: "1.2.3", PID: pid}, "/r4/Patient", "identifier", "1.2.3|" + pid},
+		{"ihepix", &PIXmOpRequest{URL: srv.URL + "/r4/Patient", PID_OID: "1.2.3", PID: pid}, "/r4/Patient/$ihe-pix", "sourceIdentifier", tukcnst.URN_OID_PREFIX + "1.2.3|" + pid},
+	}
+	for _, tt := range tests {
+		if err := NewRequest(tt.req); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got.URL.Path != tt.path {
+			t.Errorf("%s: path = %q, want %q", tt.name, got.URL.Path, tt.path)
+		}
+		query := got.URL.Query()
+		if vals := query[tt.param]; len(vals) != 1 || vals[0] != tt.want {
+			t.Errorf("%s: %s = %q, want [%q]", tt.name, tt.param, vals, tt.want)
+		}
+		if vals := query["_format"]; len(vals) != 1 || vals[0] != tukcnst.JSON {
+			t.Errorf("%s: _format = %q, want [%q]", tt.name, vals, tukcnst.JSON)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package tukpdq
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+// CacheKey identifies a cached pdq server response. Responses are cached per server type and url, so the responses from different servers, and the same id issued by different domains, never collide.
+// Target_Systems is the comma separated, sorted target system filter of a PIX query, so responses filtered to different domains never collide
+type CacheKey struct {
+	Server_Mode    string
+	Server_URL     string
+	PID            string
+	PID_OID        string
+	Target_Systems string
+}
+
+// CacheEntry is a cached pdq server response. Not_Found is true if the response found no patient. Get sets Stale if the entry has expired but is within the CachePolicy Stale_TTL
+type CacheEntry struct {
+	Response  []byte    `json:"response"`
+	Not_Found bool      `json:"notfound,omitempty"`
+	Created   time.Time `json:"created"`
+	Stale     bool      `json:"-"`
+}
+
+// CachePolicy sets how long patient cache entries are used for. TTL applies to responses that found the patient and Not_Found_TTL to responses that did not.
+//
+// A Not_Found_TTL of 0 is set to the default of 1 minute and a negative Not_Found_TTL disables caching of not found responses.
+// If Stale_TTL is set, an entry that expired less than Stale_TTL ago is still returned by Get, marked Stale, and the PDQ query returns it immediately while refreshing the entry in the background
+type CachePolicy struct {
+	TTL           time.Duration
+	Not_Found_TTL time.Duration
+	Stale_TTL     time.Duration
+}
+
+// CacheStats are the number of entries held by the patient cache and the hit, miss and eviction counts since the cache was created. Stale is the number of hits on stale entries
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Stale     uint64 `json:"stale"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// PatientCacheStore is implemented by the patient cache backends. PatientCache holds the cached responses in memory and KVCache holds them in a KVStore (FileStore or DynamoDBStore).
+// ctx is the transaction context, so a cache read or write is cancelled if the transaction is cancelled or reaches its deadline
+type PatientCacheStore interface {
+	Get(ctx context.Context, key CacheKey) (CacheEntry, bool)
+	Set(ctx context.Context, key CacheKey, entry CacheEntry)
+	Delete(ctx context.Context, key CacheKey)
+	DeletePatient(ctx context.Context, pid string, oid string)
+	Stats() CacheStats
+}
+
+// PatientCache is an in memory, concurrency safe cache of pdq server responses. Entries expire as set by the CachePolicy and, when Max_Entries is reached, the least recently used entry is evicted.
+//
+// Use NewPatientCache to create a PatientCache and SetPatientCache to replace the default cache (15 minute TTL, 1 minute not found TTL, 1000 entries) used by PDQ queries
+type PatientCache struct {
+	CachePolicy
+	Max_Entries int
+	mu          sync.Mutex
+	entries     map[CacheKey]*list.Element
+	lru         *list.List
+	stats       CacheStats
+}
+type cacheEntry struct {
+	key   CacheKey
+	entry CacheEntry
+}
+
+const (
+	patientCacheDefaultTTL         = 15 * time.Minute
+	patientCacheDefaultNotFoundTTL = time.Minute
+	patientCacheDefaultMaxEntries  = 1000
+)
+
+var (
+	pat_cache            PatientCacheStore = NewPatientCache(CachePolicy{}, 0)
+	pat_cache_refreshing sync.Map
+)
+
+// NewPatientCache returns an empty PatientCache. A policy TTL or maxEntries of 0 or less is set to the default of 15 minutes and 1000 entries
+func NewPatientCache(policy CachePolicy, maxEntries int) *PatientCache {
+	if maxEntries <= 0 {
+		maxEntries = patientCacheDefaultMaxEntries
+	}
+	return &PatientCache{
+		CachePolicy: policy.withDefaults(),
+		Max_Entries: maxEntries,
+		entries:     make(map[CacheKey]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// SetPatientCache replaces the patient cache used by PDQ queries
+func SetPatientCache(cache PatientCacheStore) {
+	if cache != nil {
+		pat_cache = cache
+	}
+}
+
+// PatientCacheStats returns the CacheStats of the patient cache used by PDQ queries
+func PatientCacheStats() CacheStats {
+	return pat_cache.Stats()
+}
+
+// Get returns the cached entry for the key. An expired entry is removed and counted as a miss
+func (c *PatientCache) Get(ctx context.Context, key CacheKey) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		if entry, ok := c.check(elem.Value.(*cacheEntry).entry); ok {
+			c.lru.MoveToFront(elem)
+			c.stats.count(entry)
+			return entry, true
+		}
+		c.remove(elem)
+	}
+	c.stats.Misses++
+	return CacheEntry{}, false
+}
+
+// Set caches the entry for the key, evicting the least recently used entry if the cache is full
+func (c *PatientCache) Set(ctx context.Context, key CacheKey, entry CacheEntry) {
+	if !c.caches(entry) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &cacheEntry{key: key, entry: entry}
+		c.lru.MoveToFront(elem)
+		return
+	}
+	for c.lru.Len() >= c.Max_Entries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, entry: entry})
+}
+
+// Delete removes the cached response for the key
+func (c *PatientCache) Delete(ctx context.Context, key CacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// DeletePatient removes the cached responses from every server for the patient id and oid
+func (c *PatientCache) DeletePatient(ctx context.Context, pid string, oid string) {
+	if pid == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.entries {
+		if key.PID == pid && key.PID_OID == oid {
+			c.remove(elem)
+			l(fmt.Sprintf("Removed %s cache entry for Patient ID %s %s", key.Server_Mode, pid, oid), true)
+		}
+	}
+}
+
+// Stats returns the current CacheStats
+func (c *PatientCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+func (c *PatientCache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+	c.lru.Remove(elem)
+}
+func (i CachePolicy) withDefaults() CachePolicy {
+	if i.TTL <= 0 {
+		i.TTL = patientCacheDefaultTTL
+	}
+	if i.Not_Found_TTL == 0 {
+		i.Not_Found_TTL = patientCacheDefaultNotFoundTTL
+	}
+	if i.Stale_TTL < 0 {
+		i.Stale_TTL = 0
+	}
+	return i
+}
+
+// caches returns false if the entry is a not found response and caching of not found responses is disabled
+func (i CachePolicy) caches(entry CacheEntry) bool {
+	return !entry.Not_Found || i.Not_Found_TTL > 0
+}
+
+// expires returns the time the entry expires. The entry may still be used until Stale_TTL after it expires
+func (i CachePolicy) expires(entry CacheEntry) time.Time {
+	if entry.Not_Found {
+		return entry.Created.Add(i.Not_Found_TTL)
+	}
+	return entry.Created.Add(i.TTL)
+}
+
+// check returns the entry, with Stale set if it has expired, and false if the entry is no longer usable and should be removed
+func (i CachePolicy) check(entry CacheEntry) (CacheEntry, bool) {
+	now := time.Now()
+	if !i.caches(entry) || !now.Before(i.expires(entry).Add(i.Stale_TTL)) {
+		return entry, false
+	}
+	entry.Stale = !now.Before(i.expires(entry))
+	return entry, true
+}
+func (i *CacheStats) count(entry CacheEntry) {
+	i.Hits++
+	if entry.Stale {
+		i.Stale++
+	}
+}
+
+// setCachedPatients sets the patients from a cached pdq server response in the same way as from a live response and marks the query as Cached with the Cache_Age of the entry in seconds and Cache_Stale if the entry has expired.
+// A Not_Found entry sets no patients. A cached PDQv3 response never returns a Continuation_Token
+func (i *PDQQuery) setCachedPatients(entry CacheEntry) error {
+	i.Response = entry.Response
+	i.StatusCode = http.StatusOK
+	i.Patients = nil
+	i.Count = 0
+	var err error
+	switch {
+	case entry.Not_Found:
+		l(fmt.Sprintf("Cached %s response for Patient ID %s %s found no patient", i.Server_Mode, i.Used_PID, i.Used_PID_OID), true)
+	case i.Server_Mode == tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3:
+		err = i.setPIXv3Patient()
+	case i.Server_Mode == tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3:
+		// the server side query of a cached response may have expired, so it is never continued or cancelled
+		if err = i.setPDQv3Patients(); err == nil {
+			i.Continuation_Token = ""
+			i.Remaining = 0
+		}
+	case i.Server_Mode == tukcnst.PDQ_SERVER_TYPE_IHE_PIXM_ITI83:
+		err = i.setPIXmParametersPatient()
+	case i.Server_Mode == tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2:
+		err = i.setPDQv2Patients()
+	case i.Server_Mode == tukcnst.PDQ_SERVER_TYPE_IHE_PIXV2:
+		err = i.setPIXv2Patient()
+	case i.Server_Mode == tukcnst.PDQ_SERVER_TYPE_IHE_PIXM, i.Server_Mode == tukcnst.PDQ_SERVER_TYPE_IHE_PDQM:
+		err = i.setPIXmBundlePatients()
+	default:
+		err = errors.New("responses from " + i.Server_Mode + " servers are not cached")
+	}
+	if err == nil && i.Count == 0 && !entry.Not_Found {
+		err = errors.New("cached response contains no patients")
+	}
+	if err != nil {
+		i.Patients = nil
+		i.Count = 0
+		return err
+	}
+	i.Cached = true
+	i.Cache_Age = int64(time.Since(entry.Created) / time.Second)
+	i.Cache_Stale = entry.Stale
+	return nil
+}
+
+// refreshCachedPatient queries the pdq server in the background to refresh the stale cache entry for the query. Only one refresh of an entry runs at a time.
+// The refresh is not part of the transaction, so it has its own context which times out after the query Timeout
+func (i *PDQQuery) refreshCachedPatient() {
+	key := i.cacheKey()
+	done := make(chan struct{})
+	if _, running := pat_cache_refreshing.LoadOrStore(key, done); running {
+		return
+	}
+	l(fmt.Sprintf("Refreshing stale %s cache entry for Patient ID %s %s", i.Server_Mode, i.Used_PID, i.Used_PID_OID), true)
+	refresh := *i
+	refresh.Patients = nil
+	refresh.Count = 0
+	refresh.PDQv3Response = nil
+	refresh.PIXv3Response = nil
+	refresh.PIXmResponse = nil
+	refresh.PIXmParametersResponse = nil
+	refresh.HL7v2Response = nil
+	refresh.HL7v3AckResponse = nil
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i.Timeout)*time.Second)
+	refresh.ctx = ctx
+	go func() {
+		defer close(done)
+		defer pat_cache_refreshing.Delete(key)
+		defer cancel()
+		if err := refresh.queryPatient(); err != nil {
+			log.Printf("Unable to refresh %s cache entry for Patient ID %s %s - %s", refresh.Server_Mode, refresh.Used_PID, refresh.Used_PID_OID, err.Error())
+		}
+	}()
+}
+
+// WaitForCacheRefreshes waits until the background refreshes of stale cache entries that are running have completed, or returns the ctx error if ctx is done first.
+// A Lambda is frozen when the handler returns, so a Lambda handler calls WaitForCacheRefreshes before returning to complete the refreshes within the invocation
+func WaitForCacheRefreshes(ctx context.Context) error {
+	var running []chan struct{}
+	pat_cache_refreshing.Range(func(key, done interface{}) bool {
+		running = append(running, done.(chan struct{}))
+		return true
+	})
+	for _, done := range running {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// cacheKey returns the CacheKey for the pdq server response to the query. The Target_Systems are sorted so the same filter in a different order has the same key
+func (i *PDQQuery) cacheKey() CacheKey {
+	targets := append([]string{}, i.Target_Systems...)
+	sort.Strings(targets)
+	return CacheKey{
+		Server_Mode:    i.Server_Mode,
+		Server_URL:     i.Server_URL,
+		PID:            i.Used_PID,
+		PID_OID:        i.Used_PID_OID,
+		Target_Systems: strings.Join(targets, ","),
+	}
+}
@@ -0,0 +1,210 @@
+package tukpdq
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+const testPDQmBundle = `{"resourceType":"Bundle","type":"searchset","total":1,"entry":[{"resource":{"resourceType":"Patient","id":"1","identifier":[{"system":"urn:oid:2.16.840.1.113883.2.1.4.1","value":"9999999468"}],"name":[{"family":"Testpatient","given":["Nhs"]}],"gender":"male","birthDate":"1962-04-04"}}]}`
+const testPDQmEmptyBundle = `{"resourceType":"Bundle","type":"searchset","total":0}`
+
+func TestTargetSystemsAreCachedSeparately(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		rsp := `{"resourceType":"Parameters","parameter":[`
+		for n, target := range r.URL.Query()["targetSystem"] {
+			if n > 0 {
+				rsp += ","
+			}
+			rsp += `{"name":"targetIdentifier","valueIdentifier":{"system":"` + target + `","value":"` + strings.TrimPrefix(target, tukcnst.URN_OID_PREFIX) + `-id"}}`
+		}
+		w.Write([]byte(rsp + `]}`))
+	}))
+	defer srv.Close()
+	SetPatientCache(NewPatientCache(CachePolicy{}, 0))
+	tests := []struct {
+		name     string
+		targets  []string
+		regid    string
+		cached   bool
+		requests int32
+	}{
+		{"reg target", []string{"1.2.3.4"}, "1.2.3.4-id", false, 1},
+		{"mrn target is not answered by the reg target response", []string{"1.2.3.5"}, "", false, 2},
+		{"no target is not answered by a filtered response", nil, "", false, 3},
+		{"reg target is answered from the cache", []string{"1.2.3.4"}, "1.2.3.4-id", true, 3},
+		{"targets in a different order share a cache entry", []string{"1.2.3.5", "1.2.3.4"}, "1.2.3.4-id", false, 4},
+		{"targets in a different order are answered from the cache", []string{"1.2.3.4", "1.2.3.5"}, "1.2.3.4-id", true, 4},
+	}
+	for _, tt := range tests {
+		pdq := PDQQuery{
+			Server_Mode:    tukcnst.PDQ_SERVER_TYPE_IHE_PIXM_ITI83,
+			Server_URL:     srv.URL,
+			NHS_ID:         "9999999468",
+			REG_OID:        "1.2.3.4",
+			Target_Systems: tt.targets,
+			Cache:          true,
+		}
+		if err := New_Transaction(&pdq); err != nil && !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if pdq.Cached != tt.cached {
+			t.Errorf("%s: Cached = %v, want %v", tt.name, pdq.Cached, tt.cached)
+		}
+		if got := atomic.LoadInt32(&requests); got != tt.requests {
+			t.Errorf("%s: server requests = %v, want %v", tt.name, got, tt.requests)
+		}
+		regid := ""
+		if pdq.Patients != nil && len(*pdq.Patients) > 0 {
+			regid = (*pdq.Patients)[0].REGID
+		}
+		if regid != tt.regid {
+			t.Errorf("%s: REGID = %q, want %q", tt.name, regid, tt.regid)
+		}
+	}
+}
+
+func TestPDQv3ContinuationIsNotCached(t *testing.T) {
+	srv, requests := newSOAPStandIn(t, strings.ReplaceAll(testPDQv3Response, "{{REMAINING}}", "5"))
+	SetPatientCache(NewPatientCache(CachePolicy{}, 0))
+	newQuery := func() *PDQQuery {
+		return &PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, Server_URL: srv.URL, NHS_ID: "9999999468", REG_OID: testREGOID, Cache: true}
+	}
+	for n := 1; n <= 2; n++ {
+		pdq := newQuery()
+		if err := New_Transaction(pdq); err != nil {
+			t.Fatal(err)
+		}
+		if pdq.Cached || pdq.Continuation_Token == "" || pdq.Remaining != 5 {
+			t.Errorf("query %v: Cached = %v Continuation_Token = %q Remaining = %v, want a live response with 5 remaining", n, pdq.Cached, pdq.Continuation_Token, pdq.Remaining)
+		}
+		if got := atomic.LoadInt32(requests); got != int32(n) {
+			t.Errorf("query %v: server requests = %v, want %v", n, got, n)
+		}
+	}
+	// an entry cached with remaining results is returned without the continuation of the expired server side query
+	pdq := newQuery()
+	pdq.Used_PID, pdq.Used_PID_OID = pdq.NHS_ID, testNHSOID
+	pat_cache.Set(context.Background(), pdq.cacheKey(), CacheEntry{Response: []byte(strings.ReplaceAll(testPDQv3Response, "{{REMAINING}}", "5")), Created: time.Now()})
+	if err := New_Transaction(pdq); err != nil {
+		t.Fatal(err)
+	}
+	if !pdq.Cached || pdq.Continuation_Token != "" || pdq.Remaining != 0 || pdq.Count != 1 {
+		t.Errorf("Cached = %v Continuation_Token = %q Remaining = %v Count = %v, want the cached patient without a continuation", pdq.Cached, pdq.Continuation_Token, pdq.Remaining, pdq.Count)
+	}
+}
+
+func TestStaleEntryIsRefreshedOnce(t *testing.T) {
+	var requests int32
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			// the refresh does not complete until its context times out
+			select {
+			case <-hang:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write([]byte(testPDQmBundle))
+	}))
+	defer srv.Close()
+	defer close(hang)
+	SetPatientCache(NewPatientCache(CachePolicy{TTL: time.Millisecond, Stale_TTL: time.Hour}, 0))
+	newQuery := func() *PDQQuery {
+		return &PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQM, Server_URL: srv.URL, NHS_ID: "9999999468", REG_OID: testREGOID, Timeout: 1, Cache: true}
+	}
+	if err := New_Transaction(newQuery()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	var wg sync.WaitGroup
+	for n := 0; n < 5; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pdq := newQuery()
+			if err := New_Transaction(pdq); err != nil || !pdq.Cached || !pdq.Cache_Stale {
+				t.Errorf("stale query: Cached = %v Cache_Stale = %v err = %v, want the stale entry", pdq.Cached, pdq.Cache_Stale, err)
+			}
+		}()
+	}
+	wg.Wait()
+	start := time.Now()
+	if err := WaitForCacheRefreshes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("refresh took %v, want the 1 second query Timeout", elapsed)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("server requests = %v, want 1 query and 1 refresh", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	newQuery().refreshCachedPatient()
+	if err := WaitForCacheRefreshes(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitForCacheRefreshes with a cancelled context err = %v, want %v", err, context.Canceled)
+	}
+	WaitForCacheRefreshes(context.Background())
+}
+
+func TestDemographicQueryIsNotCached(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if bd := r.URL.Query().Get("birthdate"); bd != "" && bd != "1962-04-04" {
+			w.Write([]byte(testPDQmEmptyBundle))
+			return
+		}
+		w.Write([]byte(testPDQmBundle))
+	}))
+	defer srv.Close()
+	SetPatientCache(NewPatientCache(CachePolicy{}, 0))
+	newQuery := func(birthdate string) *PDQQuery {
+		return &PDQQuery{
+			Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQM,
+			Server_URL:  srv.URL,
+			NHS_ID:      "9999999468",
+			REG_OID:     "2.16.840.1.113883.2.1.3.31.2.1.1",
+			BirthDate:   birthdate,
+			Cache:       true,
+		}
+	}
+	tests := []struct {
+		name      string
+		birthdate string
+		notFound  bool
+		cached    bool
+		requests  int32
+	}{
+		{"filtered query finds no patient", "20000101", true, false, 1},
+		{"id query is not answered by the filtered not found response", "", false, false, 2},
+		{"id query is answered from the cache", "", false, true, 2},
+		{"filtered query is not answered by the cached id response", "20000101", true, false, 3},
+	}
+	for _, tt := range tests {
+		pdq := newQuery(tt.birthdate)
+		err := New_Transaction(pdq)
+		if got := errors.Is(err, ErrNotFound); got != tt.notFound {
+			t.Errorf("%s: ErrNotFound = %v, want %v (err %v)", tt.name, got, tt.notFound, err)
+		}
+		if pdq.Cached != tt.cached {
+			t.Errorf("%s: Cached = %v, want %v", tt.name, pdq.Cached, tt.cached)
+		}
+		if got := atomic.LoadInt32(&requests); got != tt.requests {
+			t.Errorf("%s: server requests = %v, want %v", tt.name, got, tt.requests)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package tukpdq
+
+import (
+	"sync"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+// HL7v3Device is the sender or receiver device of a HL7 v3 message. Device_OID and Org_OID are the device and represented organisation id roots
+// and Device_Authority_Name and Org_Authority_Name are the optional assigningAuthorityName of each id
+type HL7v3Device struct {
+	Device_OID            string `json:",omitempty"`
+	Device_Authority_Name string `json:",omitempty"`
+	Org_OID               string `json:",omitempty"`
+	Org_Authority_Name    string `json:",omitempty"`
+}
+
+// DeviceProfile is the sender and receiver device used in the HL7 v3 messages sent to a server type
+type DeviceProfile struct {
+	Sender   HL7v3Device `json:",omitempty"`
+	Receiver HL7v3Device `json:",omitempty"`
+}
+
+var (
+	device_profiles    = make(map[string]DeviceProfile)
+	device_profiles_mu sync.RWMutex
+	// defaultDeviceProfiles are the devices used if no profile is set for the server type
+	defaultDeviceProfiles = map[string]DeviceProfile{
+		tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3: {
+			Sender:   HL7v3Device{Device_OID: "1.3.6.1.4.1.21367.2011.2.2.7919", Device_Authority_Name: "EHR_TIANI-SPIRIT", Org_OID: "1.3.6.1.4.1.21367.2011.2.7.5572", Org_Authority_Name: "Tiani-Cisco"},
+			Receiver: HL7v3Device{Device_OID: "1.3.6.1.4.1.21367.2009.2.2.795", Org_OID: "1.3.6.1.4.1.21367.2009.2.2.1"},
+		},
+		tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3: {
+			Sender:   HL7v3Device{Device_OID: "1.3.6.1.4.1.21367.2011.2.2.7919", Device_Authority_Name: "NHS", Org_OID: "1.3.6.1.4.1.21367.2011.2.7.5572", Org_Authority_Name: "ICB"},
+			Receiver: HL7v3Device{Device_OID: "1.3.6.1.4.1.21367.2009.2.2.795", Org_OID: "1.3.6.1.4.1.21367.2009.2.2.1"},
+		},
+		tukcnst.PDQ_SERVER_TYPE_IHE_XCPD: {
+			Sender: HL7v3Device{Device_OID: "1.3.6.1.4.1.21367.2011.2.2.7919", Device_Authority_Name: "EHR_TIANI-SPIRIT"},
+		},
+	}
+)
+
+// SetDeviceProfile sets the sender and receiver device used in HL7 v3 messages sent to the server type srv (eg pdqv3, pixv3 or xcpd).
+// A profile set for srv "" is used for any server type without its own profile. Empty profile values are taken from the srv "" profile and then the built in default for the server type
+func SetDeviceProfile(srv string, profile DeviceProfile) {
+	device_profiles_mu.Lock()
+	defer device_profiles_mu.Unlock()
+	device_profiles[srv] = profile
+}
+
+// setDevices sets any empty Sender and Receiver device values of the pdq from the DeviceProfile for the Server_Mode and then the "" DeviceProfile.
+// For xcpd, unless set in the xcpd DeviceProfile, the receiver device and organisation are the gateway Community_OID and the sender organisation is the Home_Community_OID.
+//
+// A device or organisation id still not set is taken, with its assigningAuthorityName, from the built in default for the Server_Mode, so a configured id is never sent with a default authority name
+func (i *PDQQuery) setDevices() {
+	device_profiles_mu.RLock()
+	profiles := []DeviceProfile{device_profiles[i.Server_Mode]}
+	if i.Server_Mode == tukcnst.PDQ_SERVER_TYPE_IHE_XCPD {
+		profiles = append(profiles, DeviceProfile{
+			Sender:   HL7v3Device{Org_OID: i.Home_Community_OID},
+			Receiver: HL7v3Device{Device_OID: i.Community_OID, Org_OID: i.Community_OID},
+		})
+	}
+	profiles = append(profiles, device_profiles[""])
+	device_profiles_mu.RUnlock()
+	for _, profile := range profiles {
+		i.Sender.setIfEmpty(profile.Sender)
+		i.Receiver.setIfEmpty(profile.Receiver)
+	}
+	i.Sender.setDefaults(defaultDeviceProfiles[i.Server_Mode].Sender)
+	i.Receiver.setDefaults(defaultDeviceProfiles[i.Server_Mode].Receiver)
+}
+
+// setDefaults sets the device and organisation ids, and their assigningAuthorityName, that are not set from the default device
+func (i *HL7v3Device) setDefaults(device HL7v3Device) {
+	if i.Device_OID == "" {
+		i.Device_OID = device.Device_OID
+		setIfNotEmpty(&i.Device_Authority_Name, device.Device_Authority_Name)
+	}
+	if i.Org_OID == "" {
+		i.Org_OID = device.Org_OID
+		setIfNotEmpty(&i.Org_Authority_Name, device.Org_Authority_Name)
+	}
+}
+func (i *HL7v3Device) setIfEmpty(device HL7v3Device) {
+	if i.Device_OID == "" {
+		i.Device_OID = device.Device_OID
+	}
+	if i.Device_Authority_Name == "" {
+		i.Device_Authority_Name = device.Device_Authority_Name
+	}
+	if i.Org_OID == "" {
+		i.Org_OID = device.Org_OID
+	}
+	if i.Org_Authority_Name == "" {
+		i.Org_Authority_Name = device.Org_Authority_Name
+	}
+}
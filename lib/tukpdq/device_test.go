@@ -0,0 +1,87 @@
+package tukpdq
+
+import (
+	"testing"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+func TestSetDevicesPrecedence(t *testing.T) {
+	t.Cleanup(func() {
+		device_profiles_mu.Lock()
+		device_profiles = make(map[string]DeviceProfile)
+		device_profiles_mu.Unlock()
+	})
+	pdqv3Default := defaultDeviceProfiles[tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3]
+	xcpdDefault := defaultDeviceProfiles[tukcnst.PDQ_SERVER_TYPE_IHE_XCPD]
+	tests := []struct {
+		name         string
+		profiles     map[string]DeviceProfile
+		pdq          PDQQuery
+		wantSender   HL7v3Device
+		wantReceiver HL7v3Device
+	}{
+		{
+			name:         "built in defaults",
+			pdq:          PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3},
+			wantSender:   pdqv3Default.Sender,
+			wantReceiver: pdqv3Default.Receiver,
+		},
+		{
+			name:         "query devices are kept",
+			profiles:     map[string]DeviceProfile{tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3: {Sender: HL7v3Device{Device_OID: "2.2"}}},
+			pdq:          PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, Sender: HL7v3Device{Device_OID: "9.9", Org_OID: "9.8"}},
+			wantSender:   HL7v3Device{Device_OID: "9.9", Org_OID: "9.8"},
+			wantReceiver: pdqv3Default.Receiver,
+		},
+		{
+			name: "mode profile before the default profile",
+			profiles: map[string]DeviceProfile{
+				tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3: {Sender: HL7v3Device{Device_OID: "2.2", Device_Authority_Name: "MODE"}},
+				"":                                {Sender: HL7v3Device{Device_OID: "1.1", Device_Authority_Name: "ANY", Org_OID: "1.2"}, Receiver: HL7v3Device{Device_OID: "1.3"}},
+			},
+			pdq:          PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3},
+			wantSender:   HL7v3Device{Device_OID: "2.2", Device_Authority_Name: "MODE", Org_OID: "1.2"},
+			wantReceiver: HL7v3Device{Device_OID: "1.3", Org_OID: pdqv3Default.Receiver.Org_OID},
+		},
+		{
+			name:         "default profile id is not sent with the built in authority name",
+			profiles:     map[string]DeviceProfile{"": {Sender: HL7v3Device{Device_OID: "1.1"}}},
+			pdq:          PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3},
+			wantSender:   HL7v3Device{Device_OID: "1.1", Org_OID: pdqv3Default.Sender.Org_OID, Org_Authority_Name: pdqv3Default.Sender.Org_Authority_Name},
+			wantReceiver: pdqv3Default.Receiver,
+		},
+		{
+			name:         "xcpd community before the default profile",
+			profiles:     map[string]DeviceProfile{"": {Sender: HL7v3Device{Org_OID: "1.2"}, Receiver: HL7v3Device{Device_OID: "1.3", Org_OID: "1.4"}}},
+			pdq:          PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_XCPD, Home_Community_OID: "7.7", Community_OID: "8.8"},
+			wantSender:   HL7v3Device{Device_OID: xcpdDefault.Sender.Device_OID, Device_Authority_Name: xcpdDefault.Sender.Device_Authority_Name, Org_OID: "7.7"},
+			wantReceiver: HL7v3Device{Device_OID: "8.8", Org_OID: "8.8"},
+		},
+		{
+			name: "xcpd profile before the community",
+			profiles: map[string]DeviceProfile{
+				tukcnst.PDQ_SERVER_TYPE_IHE_XCPD: {Sender: HL7v3Device{Org_OID: "3.1"}, Receiver: HL7v3Device{Device_OID: "3.2"}},
+			},
+			pdq:          PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_XCPD, Home_Community_OID: "7.7", Community_OID: "8.8"},
+			wantSender:   HL7v3Device{Device_OID: xcpdDefault.Sender.Device_OID, Device_Authority_Name: xcpdDefault.Sender.Device_Authority_Name, Org_OID: "3.1"},
+			wantReceiver: HL7v3Device{Device_OID: "3.2", Org_OID: "8.8"},
+		},
+	}
+	for _, tt := range tests {
+		device_profiles_mu.Lock()
+		device_profiles = make(map[string]DeviceProfile)
+		device_profiles_mu.Unlock()
+		for srv, profile := range tt.profiles {
+			SetDeviceProfile(srv, profile)
+		}
+		pdq := tt.pdq
+		pdq.setDevices()
+		if pdq.Sender != tt.wantSender {
+			t.Errorf("%s: Sender = %+v, want %+v", tt.name, pdq.Sender, tt.wantSender)
+		}
+		if pdq.Receiver != tt.wantReceiver {
+			t.Errorf("%s: Receiver = %+v, want %+v", tt.name, pdq.Receiver, tt.wantReceiver)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package tukpdq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The errors returned by New_Transaction and New_Feed wrap ErrInvalidRequest if the request is missing required values or has invalid values,
+// ErrNotFound if no patient matched the query and ErrTimeout (as a TimeoutError) if the pdq server did not respond in time. Use errors.Is to test for them
+var (
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrNotFound       = errors.New("no patient found")
+	ErrTimeout        = errors.New("pdq server timeout")
+)
+
+// AckError is returned when the acknowledgement code of a HL7 v2 or HL7 v3 pdq server response is not AA (or CA). Detail is any acknowledgement text or error detail returned with the code
+// and Details are the HL7 v3 acknowledgementDetails
+type AckError struct {
+	Code    string      `json:"code"`
+	Detail  string      `json:"detail,omitempty"`
+	Details []AckDetail `json:"details,omitempty"`
+}
+
+// AckDetail is a HL7 v3 acknowledgementDetail. TypeCode is E (error), W (warning) or I (information) and Location is the path of the request element in error, if given
+type AckDetail struct {
+	TypeCode   string `json:"typecode,omitempty"`
+	Code       string `json:"code,omitempty"`
+	CodeSystem string `json:"codesystem,omitempty"`
+	Text       string `json:"text,omitempty"`
+	Location   string `json:"location,omitempty"`
+}
+
+// SOAPFaultError is returned when a SOAP pdq server returns a SOAP 1.2 or SOAP 1.1 Fault. Subcode is the SOAP 1.2 Subcode value, if any, and Detail is the text content of the Fault Detail
+type SOAPFaultError struct {
+	Code    string `json:"code"`
+	Subcode string `json:"subcode,omitempty"`
+	Reason  string `json:"reason"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// HTTPStatusError is returned when a pdq server returns an unexpected http status code. Detail is any FHIR OperationOutcome issue diagnostics returned with the status
+type HTTPStatusError struct {
+	Server_Mode string
+	StatusCode  int
+	Detail      string
+}
+
+// CorrelationError is returned when the WS-Addressing RelatesTo of a SOAP pdq server response, or the MSA-2 message control id of a HL7 v2 response, is missing or does not match the Message_ID of the request
+type CorrelationError struct {
+	Message_ID string `json:"messageid"`
+	RelatesTo  string `json:"relatesto,omitempty"`
+}
+
+// TimeoutError is returned when the pdq server request times out or the transaction context reaches its deadline. errors.Is(err, ErrTimeout) is true for a TimeoutError and Err is the underlying error
+type TimeoutError struct {
+	Err error
+}
+type soapFault struct {
+	Body struct {
+		Fault *struct {
+			Code struct {
+				Value   string `xml:"Value"`
+				Subcode struct {
+					Value string `xml:"Value"`
+				} `xml:"Subcode"`
+			} `xml:"Code"`
+			Reason struct {
+				Text string `xml:"Text"`
+			} `xml:"Reason"`
+			Detail struct {
+				InnerXML string `xml:",innerxml"`
+			} `xml:"Detail"`
+			FaultCode   string `xml:"faultcode"`
+			FaultString string `xml:"faultstring"`
+			FaultDetail struct {
+				InnerXML string `xml:",innerxml"`
+			} `xml:"detail"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+type soapRelatesTo struct {
+	Header struct {
+		RelatesTo string `xml:"RelatesTo"`
+	} `xml:"Header"`
+}
+type operationOutcome struct {
+	ResourceType string `json:"resourceType"`
+	Issue        []struct {
+		Diagnostics string `json:"diagnostics"`
+	} `json:"issue"`
+}
+
+func (e *AckError) Error() string {
+	if e.Detail == "" {
+		return "acknowledgement code not equal aa, received " + e.Code
+	}
+	return "acknowledgement code not equal aa, received " + e.Code + " - " + e.Detail
+}
+func (e *SOAPFaultError) Error() string {
+	msg := "soap fault " + e.Code
+	if e.Subcode != "" {
+		msg = msg + " " + e.Subcode
+	}
+	msg = msg + " - " + e.Reason
+	if e.Detail != "" {
+		msg = msg + " - " + e.Detail
+	}
+	return msg
+}
+func (e *HTTPStatusError) Error() string {
+	if e.Detail == "" {
+		return e.Server_Mode + " server returned http status " + strconv.Itoa(e.StatusCode)
+	}
+	return e.Server_Mode + " server returned http status " + strconv.Itoa(e.StatusCode) + " - " + e.Detail
+}
+func (e *CorrelationError) Error() string {
+	if e.RelatesTo == "" {
+		return "pdq server response has no RelatesTo for message id " + e.Message_ID
+	}
+	return "pdq server response RelatesTo " + e.RelatesTo + " does not match message id " + e.Message_ID
+}
+func (e *TimeoutError) Error() string {
+	return ErrTimeout.Error() + " - " + e.Err.Error()
+}
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// newInvalidRequestError returns an error wrapping ErrInvalidRequest with the message "invalid request - msg"
+func newInvalidRequestError(msg string) error {
+	return fmt.Errorf("%w - %s", ErrInvalidRequest, msg)
+}
+
+// newHL7v3AckError returns an AckError for the acknowledgement code with the acknowledgementDetails. Detail is set to the code and text of each detail, separated by " - "
+func newHL7v3AckError(code string, ackdetails []HL7v3AcknowledgementDetail) *AckError {
+	ackerr := AckError{Code: code}
+	texts := []string{}
+	for _, ackdetail := range ackdetails {
+		detail := AckDetail{
+			TypeCode:   ackdetail.TypeCode,
+			Code:       ackdetail.Code.Code,
+			CodeSystem: ackdetail.Code.CodeSystem,
+			Text:       strings.Join(strings.Fields(ackdetail.Text), " "),
+			Location:   strings.TrimSpace(ackdetail.Location),
+		}
+		if detail.Text == "" {
+			detail.Text = ackdetail.Code.DisplayName
+		}
+		ackerr.Details = append(ackerr.Details, detail)
+		if text := strings.TrimSpace(detail.Code + " " + detail.Text); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	ackerr.Detail = strings.Join(texts, " - ")
+	return &ackerr
+}
+
+// newNotFoundError returns an error wrapping ErrNotFound for a query that found no patient, or a HTTPStatusError if the pdq server returned an error status
+func (i *PDQQuery) newNotFoundError() error {
+	if i.StatusCode >= http.StatusBadRequest && i.StatusCode != http.StatusNotFound {
+		return &HTTPStatusError{Server_Mode: i.Server_Mode, StatusCode: i.StatusCode}
+	}
+	if i.Used_PID == "" {
+		return fmt.Errorf("%w matching demographics", ErrNotFound)
+	}
+	return fmt.Errorf("%w matching %s %s", ErrNotFound, i.Used_PID, i.Used_PID_OID)
+}
+
+// newHTTPStatusError returns a HTTPStatusError for the pdq server response StatusCode with the diagnostics of any FHIR OperationOutcome issues in the Response
+func (i *PDQQuery) newHTTPStatusError() *HTTPStatusError {
+	return &HTTPStatusError{Server_Mode: i.Server_Mode, StatusCode: i.StatusCode, Detail: getOperationOutcomeDiagnostics(i.Response)}
+}
+
+// newSOAPFaultError returns a SOAPFaultError if the Response is a SOAP 1.2 or SOAP 1.1 Fault, otherwise nil
+func (i *PDQQuery) newSOAPFaultError() error {
+	if !bytes.Contains(i.Response, []byte("Fault>")) {
+		return nil
+	}
+	fault := soapFault{}
+	if err := xml.Unmarshal(i.Response, &fault); err != nil || fault.Body.Fault == nil {
+		return nil
+	}
+	if fault.Body.Fault.Code.Value != "" {
+		return &SOAPFaultError{
+			Code:    strings.TrimSpace(fault.Body.Fault.Code.Value),
+			Subcode: strings.TrimSpace(fault.Body.Fault.Code.Subcode.Value),
+			Reason:  strings.TrimSpace(fault.Body.Fault.Reason.Text),
+			Detail:  getXMLText(fault.Body.Fault.Detail.InnerXML),
+		}
+	}
+	return &SOAPFaultError{
+		Code:   strings.TrimSpace(fault.Body.Fault.FaultCode),
+		Reason: strings.TrimSpace(fault.Body.Fault.FaultString),
+		Detail: getXMLText(fault.Body.Fault.FaultDetail.InnerXML),
+	}
+}
+
+// getXMLText returns the text content of the xml elements, with runs of white space collapsed to a single space
+func getXMLText(innerxml string) string {
+	text := []string{}
+	dec := xml.NewDecoder(strings.NewReader(innerxml))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if data, ok := tok.(xml.CharData); ok {
+			text = append(text, strings.Fields(string(data))...)
+		}
+	}
+	return strings.Join(text, " ")
+}
+
+// checkRelatesTo returns a CorrelationError if the WS-Addressing RelatesTo of the Response is missing or is not the Message_ID of the request. The urn: and uuid: prefixes are ignored
+func (i *PDQQuery) checkRelatesTo() error {
+	rsp := soapRelatesTo{}
+	xml.Unmarshal(i.Response, &rsp)
+	relatesto := strings.TrimSpace(rsp.Header.RelatesTo)
+	if strings.TrimPrefix(strings.TrimPrefix(relatesto, "urn:"), "uuid:") != i.Message_ID || i.Message_ID == "" {
+		return &CorrelationError{Message_ID: i.Message_ID, RelatesTo: relatesto}
+	}
+	return nil
+}
+
+// getOperationOutcomeDiagnostics returns the issue diagnostics of a FHIR OperationOutcome, separated by " - "
+func getOperationOutcomeDiagnostics(rsp []byte) string {
+	outcome := operationOutcome{}
+	if err := json.Unmarshal(rsp, &outcome); err != nil {
+		return ""
+	}
+	diagnostics := []string{}
+	for _, issue := range outcome.Issue {
+		if issue.Diagnostics != "" {
+			diagnostics = append(diagnostics, issue.Diagnostics)
+		}
+	}
+	return strings.Join(diagnostics, " - ")
+}
+
+// getTimeoutError returns err as a TimeoutError if err is a context deadline or network timeout error, otherwise err
+func getTimeoutError(err error) error {
+	var neterr net.Error
+	if errors.Is(err, ErrTimeout) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &neterr) && neterr.Timeout() {
+		return &TimeoutError{Err: err}
+	}
+	return err
+}
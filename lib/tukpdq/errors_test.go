@@ -0,0 +1,101 @@
+package tukpdq
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewSOAPFaultError(t *testing.T) {
+	tests := []struct {
+		name string
+		rsp  string
+		want *SOAPFaultError
+	}{
+		{
+			name: "soap 1.2 fault",
+			rsp: `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><S:Body><S:Fault><S:Code><S:Value>S:Receiver</S:Value><S:Subcode><S:Value>wsa:ActionNotSupported</S:Value></S:Subcode></S:Code>` +
+				`<S:Reason><S:Text xml:lang="en"> Action not supported </S:Text></S:Reason><S:Detail><error><code>501</code>  <text>Unknown   action</text></error></S:Detail></S:Fault></S:Body></S:Envelope>`,
+			want: &SOAPFaultError{Code: "S:Receiver", Subcode: "wsa:ActionNotSupported", Reason: "Action not supported", Detail: "501 Unknown action"},
+		},
+		{
+			name: "soap 1.1 fault",
+			rsp: `<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body><soapenv:Fault><faultcode>soapenv:Server</faultcode>` +
+				`<faultstring>Internal Error</faultstring><detail><message>Database unavailable</message></detail></soapenv:Fault></soapenv:Body></soapenv:Envelope>`,
+			want: &SOAPFaultError{Code: "soapenv:Server", Reason: "Internal Error", Detail: "Database unavailable"},
+		},
+		{
+			name: "soap 1.1 fault without detail",
+			rsp:  `<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body><soapenv:Fault><faultcode>soapenv:Client</faultcode><faultstring>Bad request</faultstring></soapenv:Fault></soapenv:Body></soapenv:Envelope>`,
+			want: &SOAPFaultError{Code: "soapenv:Client", Reason: "Bad request"},
+		},
+		{
+			name: "response without a fault",
+			rsp:  `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><S:Body><PRPA_IN201306UV02/></S:Body></S:Envelope>`,
+		},
+		{
+			name: "fault element outside the body",
+			rsp:  `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><S:Header><Fault>header</Fault></S:Header><S:Body/></S:Envelope>`,
+		},
+		{
+			name: "malformed fault",
+			rsp:  `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><S:Body><S:Fault>`,
+		},
+	}
+	for _, tt := range tests {
+		pdq := PDQQuery{Response: []byte(tt.rsp)}
+		err := pdq.newSOAPFaultError()
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: err = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		var faulterr *SOAPFaultError
+		if !errors.As(err, &faulterr) {
+			t.Errorf("%s: err = %v, want SOAPFaultError", tt.name, err)
+			continue
+		}
+		if *faulterr != *tt.want {
+			t.Errorf("%s: SOAPFaultError = %+v, want %+v", tt.name, *faulterr, *tt.want)
+		}
+	}
+}
+
+func TestCheckRelatesTo(t *testing.T) {
+	const msgid = "5f1c2e9a-7d2b-4c1e-9a3f-0b6d8e2c4a10"
+	header := func(relatesto string) string {
+		return `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><S:Header>` + relatesto + `</S:Header><S:Body><PRPA_IN201306UV02/></S:Body></S:Envelope>`
+	}
+	tests := []struct {
+		name          string
+		msgid         string
+		rsp           string
+		wantErr       bool
+		wantRelatesTo string
+	}{
+		{"uuid prefix", msgid, header(`<RelatesTo xmlns="http://www.w3.org/2005/08/addressing">uuid:` + msgid + `</RelatesTo>`), false, ""},
+		{"urn uuid prefix", msgid, header(`<wsa:RelatesTo xmlns:wsa="http://www.w3.org/2005/08/addressing"> urn:uuid:` + msgid + ` </wsa:RelatesTo>`), false, ""},
+		{"no prefix", msgid, header(`<RelatesTo xmlns="http://www.w3.org/2005/08/addressing">` + msgid + `</RelatesTo>`), false, ""},
+		{"missing RelatesTo", msgid, header(""), true, ""},
+		{"mismatched RelatesTo", msgid, header(`<RelatesTo xmlns="http://www.w3.org/2005/08/addressing">urn:uuid:00000000-0000-0000-0000-000000000001</RelatesTo>`), true, "urn:uuid:00000000-0000-0000-0000-000000000001"},
+		{"request has no message id", "", header(`<RelatesTo xmlns="http://www.w3.org/2005/08/addressing"></RelatesTo>`), true, ""},
+	}
+	for _, tt := range tests {
+		pdq := PDQQuery{Message_ID: tt.msgid, Response: []byte(tt.rsp)}
+		err := pdq.checkRelatesTo()
+		if !tt.wantErr {
+			if err != nil {
+				t.Errorf("%s: err = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		var correrr *CorrelationError
+		if !errors.As(err, &correrr) {
+			t.Errorf("%s: err = %v, want CorrelationError", tt.name, err)
+			continue
+		}
+		if correrr.Message_ID != tt.msgid || correrr.RelatesTo != tt.wantRelatesTo {
+			t.Errorf("%s: CorrelationError = %+v, want message id %q and RelatesTo %q", tt.name, *correrr, tt.msgid, tt.wantRelatesTo)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package tukpdq
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ipthomas/tukcnst"
+	"github.com/ipthomas/tukhttp"
+)
+
+// PIXFeedInterface is implemented by PDQQuery to register (Feed_Action add) or update (Feed_Action revise) a patient with a PIX manager
+type PIXFeedInterface interface {
+	feed(ctx context.Context) error
+}
+
+// FHIRPatient is the FHIR Patient resource sent to a PIXm server in an ITI-104 Patient Identity Feed
+type FHIRPatient struct {
+	ResourceType string           `json:"resourceType"`
+	Identifier   []FHIRIdentifier `json:"identifier"`
+	Active       bool             `json:"active"`
+	Name         []FHIRName       `json:"name,omitempty"`
+	Telecom      []FHIRTelecom    `json:"telecom,omitempty"`
+	Gender       string           `json:"gender,omitempty"`
+	BirthDate    string           `json:"birthDate,omitempty"`
+	Address      []FHIRAddress    `json:"address,omitempty"`
+}
+type FHIRIdentifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+type FHIRName struct {
+	Use    string   `json:"use,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+}
+type FHIRTelecom struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+type FHIRAddress struct {
+	Line       []string `json:"line,omitempty"`
+	City       string   `json:"city,omitempty"`
+	State      string   `json:"state,omitempty"`
+	PostalCode string   `json:"postalCode,omitempty"`
+	Country    string   `json:"country,omitempty"`
+}
+
+// New_Feed sends a Patient Identity Feed for the patient ids and demographics set in the PDQQuery.
+//
+// Server_Mode pixv3 sends an IHE ITI-44 PRPA_IN201301UV02 (add) or PRPA_IN201302UV02 (revise) message and Ack_Code is set from the MCCI_IN000002UV01 acknowledgement.
+//
+// Server_Mode pixm sends an IHE ITI-104 conditional update (PUT Patient?identifier=) which creates or updates the patient. Ack_Code is set to AA if the server returns 200 or 201
+func New_Feed(i PIXFeedInterface) error {
+	return New_FeedWithContext(context.Background(), i)
+}
+
+// New_FeedWithContext sends the Patient Identity Feed using ctx, so the feed is cancelled if ctx is cancelled or reaches its deadline before the Timeout
+func New_FeedWithContext(ctx context.Context, i PIXFeedInterface) error {
+	return i.feed(ctx)
+}
+func (i *PDQQuery) feed(ctx context.Context) error {
+	i.ctx = ctx
+	switch i.Feed_Action {
+	case "":
+		i.Feed_Action = tukcnst.PIX_FEED_ACTION_ADD
+	case tukcnst.PIX_FEED_ACTION_ADD, tukcnst.PIX_FEED_ACTION_REVISE:
+	default:
+		return newInvalidRequestError("pix feed action must be add or revise")
+	}
+	i.Cache = false
+	if err := i.setPDQ_ID(); err != nil {
+		return err
+	}
+	var err error
+	i.StatusCode = http.StatusOK
+	switch i.Server_Mode {
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3:
+		soapaction := tukcnst.SOAP_ACTION_PIXV3_Add_Request
+		if i.Feed_Action == tukcnst.PIX_FEED_ACTION_REVISE {
+			soapaction = tukcnst.SOAP_ACTION_PIXV3_Revise_Request
+		}
+		if err = i.newIHESOAPTemplateRequest(pixv3FeedTemplate, soapaction); err == nil {
+			if err = i.setHL7v3Ack(); i.HL7v3AckResponse != nil {
+				i.Ack_Code = i.HL7v3AckResponse.Body.MCCIIN000002UV01.Acknowledgement.TypeCode.Code
+			}
+		}
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PIXM:
+		err = i.newPIXmFeed()
+	default:
+		err = newInvalidRequestError("pix feed is only supported by pixv3 and pixm servers")
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return getTimeoutError(err)
+	}
+	pat_cache.DeletePatient(i.getContext(), i.Used_PID, i.Used_PID_OID)
+	pat_cache.DeletePatient(i.getContext(), i.NHS_ID, i.NHS_OID)
+	pat_cache.DeletePatient(i.getContext(), i.REG_ID, i.REG_OID)
+	log.Printf("PIX %s feed for patient %s %s acknowledged %s", i.Feed_Action, i.Used_PID, i.Used_PID_OID, i.Ack_Code)
+	return nil
+}
+
+// newPIXmFeed sends the FHIRPatient for the pdq to the PIXm server as a conditional update on the Used_PID identifier
+func (i *PDQQuery) newPIXmFeed() error {
+	var err error
+	if i.Request, err = json.Marshal(i.newFHIRPatient()); err != nil {
+		return err
+	}
+	httpReq := tukhttp.FHIRRequest{
+		Method:  http.MethodPut,
+		Body:    i.Request,
+		Timeout: i.Timeout,
+	}
+	if httpReq.URL, err = tukhttp.NewURL(strings.TrimSuffix(i.Server_URL, "/"), "", url.Values{"identifier": {tukcnst.URN_OID_PREFIX + i.Used_PID_OID + "|" + i.Used_PID}}); err != nil {
+		return err
+	}
+	err = tukhttp.NewRequestWithContext(i.getContext(), &httpReq)
+	i.Response = httpReq.Response
+	i.StatusCode = httpReq.StatusCode
+	if err != nil {
+		return err
+	}
+	switch i.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		i.Ack_Code = "AA"
+		return nil
+	}
+	json.Unmarshal(i.Response, &i.PIXmParametersResponse)
+	i.Ack_Code = "AE"
+	statuserr := i.newHTTPStatusError()
+	switch i.StatusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return newInvalidRequestError(strings.TrimSuffix("pixm server rejected patient feed - "+statuserr.Detail, " - "))
+	}
+	return statuserr
+}
+
+// newFHIRPatient returns a FHIRPatient containing the patient ids and demographics set in the pdq
+func (i *PDQQuery) newFHIRPatient() FHIRPatient {
+	pat := FHIRPatient{
+		ResourceType: "Patient",
+		Active:       true,
+		Identifier:   []FHIRIdentifier{{System: tukcnst.URN_OID_PREFIX + i.Used_PID_OID, Value: i.Used_PID}},
+		BirthDate:    getFhirDate(i.BirthDate),
+	}
+	if i.NHS_ID != "" && i.NHS_OID != i.Used_PID_OID {
+		pat.Identifier = append(pat.Identifier, FHIRIdentifier{System: tukcnst.URN_OID_PREFIX + i.NHS_OID, Value: i.NHS_ID})
+	}
+	if i.REG_ID != "" && i.REG_OID != i.Used_PID_OID {
+		pat.Identifier = append(pat.Identifier, FHIRIdentifier{System: tukcnst.URN_OID_PREFIX + i.REG_OID, Value: i.REG_ID})
+	}
+	if i.MRN_ID != "" && i.MRN_OID != "" && i.MRN_OID != i.Used_PID_OID {
+		pat.Identifier = append(pat.Identifier, FHIRIdentifier{System: tukcnst.URN_OID_PREFIX + i.MRN_OID, Value: i.MRN_ID})
+	}
+	if i.GivenName != "" || i.FamilyName != "" {
+		name := FHIRName{Use: "official", Family: i.FamilyName}
+		if i.GivenName != "" {
+			name.Given = strings.Fields(i.GivenName)
+		}
+		pat.Name = []FHIRName{name}
+	}
+	if i.Phone != "" {
+		pat.Telecom = append(pat.Telecom, FHIRTelecom{System: "phone", Value: i.Phone})
+	}
+	if i.Email != "" {
+		pat.Telecom = append(pat.Telecom, FHIRTelecom{System: "email", Value: i.Email})
+	}
+	if i.Gender != "" {
+		pat.Gender = getFhirGender(getHL7Gender(i.Gender))
+	}
+	if i.Street != "" || i.Town != "" || i.City != "" || i.Zip != "" || i.Country != "" {
+		addr := FHIRAddress{City: i.City, PostalCode: i.Zip, Country: i.Country}
+		for _, line := range []string{i.Street, i.Town} {
+			if line != "" {
+				addr.Line = append(addr.Line, line)
+			}
+		}
+		pat.Address = []FHIRAddress{addr}
+	}
+	return pat
+}
@@ -0,0 +1,86 @@
+package tukpdq
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+func TestPIXmFeed(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		rsp        string
+		wantAck    string
+		wantErr    error
+		wantDetail string
+	}{
+		{"created", http.StatusCreated, `{"resourceType":"Patient","id":"1"}`, "AA", nil, ""},
+		{"updated", http.StatusOK, `{"resourceType":"Patient","id":"1"}`, "AA", nil, ""},
+		{"bad request", http.StatusBadRequest, `{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"invalid","diagnostics":"identifier system is not known"}]}`, "AE", ErrInvalidRequest, "identifier system is not known"},
+		{"unprocessable entity", http.StatusUnprocessableEntity, `{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"business-rule","diagnostics":"birthDate is in the future"}]}`, "AE", ErrInvalidRequest, "birthDate is in the future"},
+		{"unprocessable entity without an operation outcome", http.StatusUnprocessableEntity, ``, "AE", ErrInvalidRequest, ""},
+	}
+	for _, tt := range tests {
+		var method, identifier string
+		var body []byte
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			identifier = r.URL.Query().Get("identifier")
+			body, _ = io.ReadAll(r.Body)
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.rsp))
+		}))
+		pdq := PDQQuery{
+			Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PIXM,
+			Server_URL:  srv.URL + "/Patient/",
+			NHS_ID:      "9999999468",
+			REG_ID:      "REG.1",
+			REG_OID:     testREGOID,
+			GivenName:   "Nhs A",
+			FamilyName:  "Testpatient",
+			BirthDate:   "19620404",
+			Gender:      "F",
+			Zip:         "PR1 1PR",
+		}
+		err := New_Feed(&pdq)
+		srv.Close()
+		if method != http.MethodPut || identifier != tukcnst.URN_OID_PREFIX+tukcnst.NHS_OID_DEFAULT+"|9999999468" {
+			t.Errorf("%s: request = %s identifier=%s, want a PUT conditional update on the nhs id", tt.name, method, identifier)
+		}
+		pat := FHIRPatient{}
+		if err := json.Unmarshal(body, &pat); err != nil || pat.ResourceType != "Patient" || len(pat.Identifier) != 2 || pat.BirthDate != "1962-04-04" || pat.Gender != "female" || len(pat.Name) != 1 || strings.Join(pat.Name[0].Given, " ") != "Nhs A" {
+			t.Errorf("%s: request body = %s, want the FHIR Patient", tt.name, body)
+		}
+		if pdq.Ack_Code != tt.wantAck || pdq.StatusCode != tt.status {
+			t.Errorf("%s: Ack_Code = %q StatusCode = %v, want %q %v", tt.name, pdq.Ack_Code, pdq.StatusCode, tt.wantAck, tt.status)
+		}
+		if tt.wantErr == nil {
+			if err != nil {
+				t.Errorf("%s: err = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.wantDetail) {
+			t.Errorf("%s: err = %v, want %v with %q", tt.name, err, tt.wantErr, tt.wantDetail)
+		}
+	}
+}
+
+func TestPIXmFeedServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	err := New_Feed(&PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PIXM, Server_URL: srv.URL, NHS_ID: "9999999468", REG_OID: testREGOID})
+	var statuserr *HTTPStatusError
+	if !errors.As(err, &statuserr) || statuserr.StatusCode != http.StatusInternalServerError || errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("err = %v, want a HTTPStatusError for http status 500", err)
+	}
+}
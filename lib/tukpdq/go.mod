@@ -0,0 +1,16 @@
+module github.com/ipthomas/tukpdq
+
+go 1.19
+
+require (
+	github.com/ipthomas/tukcnst v1.3.3
+	github.com/ipthomas/tukhttp v1.3.3
+	github.com/ipthomas/tukutil v1.3.3
+)
+
+require github.com/google/uuid v1.3.0 // indirect
+
+replace (
+	github.com/ipthomas/tukcnst => ../tukcnst
+	github.com/ipthomas/tukhttp => ../tukhttp
+)
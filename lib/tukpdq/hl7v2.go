@@ -0,0 +1,339 @@
+package tukpdq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+// HL7v2Response contains the acknowledgement, query acknowledgement and PID segments parsed from a HL7 v2 response message
+type HL7v2Response struct {
+	MessageType      string     `json:"messagetype"`
+	MessageControlID string     `json:"messagecontrolid"`
+	AckCode          string     `json:"ackcode"`
+	AckText          string     `json:"acktext,omitempty"`
+	AckMessageID     string     `json:"ackmessageid,omitempty"`
+	QueryStatus      string     `json:"querystatus,omitempty"`
+	ErrorCode        string     `json:"errorcode,omitempty"`
+	Error            string     `json:"error,omitempty"`
+	PID              [][]string `json:"pid,omitempty"`
+	encoding         hl7v2Encoding
+}
+
+// hl7v2Encoding contains the HL7 v2 delimiters declared in the MSH segment
+type hl7v2Encoding struct {
+	field        string
+	component    string
+	repetition   string
+	escape       string
+	subcomponent string
+}
+
+const (
+	mllpStartBlock = 0x0b
+	mllpEndBlock   = 0x1c
+	mllpCR         = 0x0d
+	mllpScheme     = "mllp://"
+)
+
+// hl7v2UnknownKeyIdentifier is the ERR code returned by a PIX manager when the queried patient id is not recognised
+const hl7v2UnknownKeyIdentifier = "204"
+
+var hl7v2DefaultEncoding = hl7v2Encoding{field: "|", component: "^", repetition: "~", escape: "\\", subcomponent: "&"}
+
+// newPDQv2Query performs an IHE ITI-21 PDQ QBP^Q22 query over MLLP and adds a TUKPatient for each PID segment returned in the RSP^K22 response
+func (i *PDQQuery) newPDQv2Query() error {
+	if err := i.newHL7v2TemplateRequest(tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2); err != nil {
+		return err
+	}
+	return i.setPDQv2Patients()
+}
+
+// setPDQv2Patients parses the RSP^K22 response and adds a TUKPatient for each PID segment
+func (i *PDQQuery) setPDQv2Patients() error {
+	if err := i.setHL7v2Response(); err != nil {
+		return err
+	}
+	for _, pid := range i.HL7v2Response.PID {
+		i.addPatient(i.newHL7v2Patient(pid))
+	}
+	return nil
+}
+
+// newPIXv2Query performs an IHE ITI-9 PIX QBP^Q23 query over MLLP for the Used_PID and Used_PID_OID. The PID-3 identifiers returned in the RSP^K23 response are mapped to the NHS, MRN and regional ids by assigning authority oid.
+// If Target_Systems is set, the query is restricted to the identifiers in those domains. A patient id the PIX manager does not recognise is treated as not found
+func (i *PDQQuery) newPIXv2Query() error {
+	if err := i.newHL7v2TemplateRequest(tukcnst.PDQ_SERVER_TYPE_IHE_PIXV2); err != nil {
+		return err
+	}
+	return i.setPIXv2Patient()
+}
+
+// setPIXv2Patient parses the RSP^K23 response and adds a TUKPatient with the returned PID-3 identifiers
+func (i *PDQQuery) setPIXv2Patient() error {
+	if err := i.setHL7v2Response(); err != nil {
+		if i.HL7v2Response != nil && i.HL7v2Response.AckCode == "AE" && i.HL7v2Response.ErrorCode == hl7v2UnknownKeyIdentifier {
+			l(fmt.Sprintf("PIX manager does not recognise patient id %s %s", i.Used_PID, i.Used_PID_OID), false)
+			return nil
+		}
+		return err
+	}
+	if len(i.HL7v2Response.PID) == 0 {
+		return nil
+	}
+	pat := i.newHL7v2Patient(i.HL7v2Response.PID[0])
+	if pat.NHSID == "" {
+		pat.NHSID = i.NHS_ID
+	}
+	if pat.REGID == "" {
+		pat.REGID = i.REG_ID
+	}
+	if pat.PID == "" && i.MRN_ID != "" {
+		pat.PID = i.MRN_ID
+		pat.PIDOID = i.MRN_OID
+	}
+	i.addPatient(pat)
+	return nil
+}
+
+// newHL7v2TemplateRequest executes the named HL7 v2 template and sends the resulting message to the Server_URL over MLLP. The Message_ID is set to the MSH-10 message control id of the message, which the response MSA-2 must match
+func (i *PDQQuery) newHL7v2TemplateRequest(name string) error {
+	if err := i.setTemplateRequest(name); err != nil {
+		return err
+	}
+	i.Message_ID = getHL7v2ControlID(i.Request)
+	return i.newMLLPRequest()
+}
+
+// newMLLPRequest sends the Request to the Server_URL (host:port or mllp://host:port) wrapped in a MLLP frame and sets the Response to the unwrapped response message.
+// The Timeout, or the transaction context deadline if earlier, applies to the whole exchange, from connecting to reading the response
+func (i *PDQQuery) newMLLPRequest() error {
+	addr := strings.TrimPrefix(i.Server_URL, mllpScheme)
+	l(fmt.Sprintf("MLLP Request\nServer = %s\nTimeout = %v\n%s", addr, i.Timeout, hl7v2Printable(i.Request)), true)
+	ctx, cancel := context.WithTimeout(i.getContext(), time.Duration(i.Timeout)*time.Second)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	frame := append([]byte{mllpStartBlock}, i.Request...)
+	frame = append(frame, mllpEndBlock, mllpCR)
+	if _, err = conn.Write(frame); err != nil {
+		return err
+	}
+	rsp, err := bufio.NewReader(conn).ReadBytes(mllpEndBlock)
+	if err != nil {
+		return err
+	}
+	if start := bytes.IndexByte(rsp, mllpStartBlock); start > -1 {
+		rsp = rsp[start+1:]
+	}
+	i.Response = bytes.TrimSuffix(rsp, []byte{mllpEndBlock})
+	l(fmt.Sprintf("MLLP Response\n%s", hl7v2Printable(i.Response)), true)
+	return nil
+}
+
+// setHL7v2Response parses the HL7 v2 Response into the HL7v2Response. A CorrelationError is returned if the MSA-2 message control id is missing or is not the Message_ID of the request and an AckError if the MSA acknowledgement code is not AA or CA
+func (i *PDQQuery) setHL7v2Response() error {
+	i.HL7v2Response = &HL7v2Response{encoding: hl7v2DefaultEncoding}
+	for _, seg := range strings.FieldsFunc(string(i.Response), func(r rune) bool { return r == '\r' || r == '\n' }) {
+		if strings.HasPrefix(seg, "MSH") && len(seg) > 8 {
+			i.HL7v2Response.encoding = hl7v2Encoding{field: seg[3:4], component: seg[4:5], repetition: seg[5:6], escape: seg[6:7], subcomponent: seg[7:8]}
+		}
+		fields := strings.Split(seg, i.HL7v2Response.encoding.field)
+		if fields[0] == "MSH" {
+			// MSH-1 is the field separator so MSH-n is fields[n-1]
+			fields = append([]string{"MSH", i.HL7v2Response.encoding.field}, fields[1:]...)
+		}
+		switch fields[0] {
+		case "MSH":
+			i.HL7v2Response.MessageType = i.HL7v2Response.getComponents(getHL7v2Field(fields, 9), 1, 2)
+			i.HL7v2Response.MessageControlID = i.HL7v2Response.unescape(getHL7v2Field(fields, 10))
+		case "MSA":
+			i.HL7v2Response.AckCode = getHL7v2Field(fields, 1)
+			i.HL7v2Response.AckMessageID = i.HL7v2Response.unescape(getHL7v2Field(fields, 2))
+			i.HL7v2Response.AckText = i.HL7v2Response.unescape(getHL7v2Field(fields, 3))
+		case "QAK":
+			i.HL7v2Response.QueryStatus = getHL7v2Field(fields, 2)
+		case "ERR":
+			if i.HL7v2Response.Error == "" {
+				i.HL7v2Response.ErrorCode = i.HL7v2Response.getComponent(getHL7v2Field(fields, 3), 1)
+				i.HL7v2Response.Error = i.HL7v2Response.getComponents(getHL7v2Field(fields, 3), 1, 2) + " " + i.HL7v2Response.unescape(getHL7v2Field(fields, 8))
+			}
+		case "PID":
+			i.HL7v2Response.PID = append(i.HL7v2Response.PID, fields)
+		}
+	}
+	if i.HL7v2Response.MessageType == "" {
+		return errors.New("invalid hl7 v2 response - no msh segment found")
+	}
+	if i.HL7v2Response.AckMessageID != i.Message_ID || i.Message_ID == "" {
+		return &CorrelationError{Message_ID: i.Message_ID, RelatesTo: i.HL7v2Response.AckMessageID}
+	}
+	if code := i.HL7v2Response.AckCode; code != "AA" && code != "CA" {
+		ackerr := AckError{Code: code, Detail: i.HL7v2Response.AckText}
+		if i.HL7v2Response.Error != "" {
+			ackerr.Detail = strings.TrimPrefix(ackerr.Detail+" - "+strings.TrimSpace(i.HL7v2Response.Error), " - ")
+		}
+		return &ackerr
+	}
+	log.Printf("%s Query Status %s - %v PID Segments in Response", i.HL7v2Response.MessageType, i.HL7v2Response.QueryStatus, len(i.HL7v2Response.PID))
+	return nil
+}
+
+// newHL7v2Patient returns a TUKPatient initialised from the fields of a PID segment. The PID-3 identifiers are mapped to the regional, NHS and MRN ids by assigning authority oid.
+// If MRN_OID is not set, the first identifier from any other domain is returned as the PID with its oid
+func (i *PDQQuery) newHL7v2Patient(pid []string) TUKPatient {
+	rsp := i.HL7v2Response
+	pat := TUKPatient{
+		REGOID: i.REG_OID,
+		NHSOID: i.NHS_OID,
+	}
+	for _, cx := range rsp.getRepetitions(getHL7v2Field(pid, 3)) {
+		id := rsp.unescape(rsp.getComponent(cx, 1))
+		oid := rsp.getSubComponent(rsp.getComponent(cx, 4), 2)
+		switch {
+		case oid == i.REG_OID:
+			pat.REGID = id
+		case oid == i.NHS_OID:
+			pat.NHSID = id
+		case pat.PID == "" && oid != "" && (oid == i.MRN_OID || i.MRN_OID == ""):
+			pat.PID = id
+			pat.PIDOID = oid
+		}
+	}
+	if names := rsp.getRepetitions(getHL7v2Field(pid, 5)); len(names) > 0 {
+		pat.FamilyName = rsp.unescape(rsp.getSubComponent(rsp.getComponent(names[0], 1), 1))
+		pat.GivenName = strings.TrimSpace(rsp.unescape(rsp.getComponent(names[0], 2) + " " + rsp.getComponent(names[0], 3)))
+	}
+	pat.BirthDate = getHL7Date(getHL7v2Field(pid, 7))
+	if sex := getHL7v2Field(pid, 8); sex != "" {
+		pat.Gender = getFhirGender(sex)
+	}
+	if addrs := rsp.getRepetitions(getHL7v2Field(pid, 11)); len(addrs) > 0 {
+		pat.Street = rsp.unescape(rsp.getSubComponent(rsp.getComponent(addrs[0], 1), 1))
+		pat.Town = rsp.unescape(rsp.getComponent(addrs[0], 2))
+		pat.City = rsp.unescape(rsp.getComponent(addrs[0], 3))
+		pat.State = rsp.unescape(rsp.getComponent(addrs[0], 4))
+		pat.Zip = rsp.unescape(rsp.getComponent(addrs[0], 5))
+		pat.Country = rsp.unescape(rsp.getComponent(addrs[0], 6))
+	}
+	for _, xtn := range rsp.getRepetitions(getHL7v2Field(pid, 13)) {
+		switch {
+		case rsp.getComponent(xtn, 3) == "Internet" && pat.Email == "":
+			pat.Email = rsp.unescape(rsp.getComponent(xtn, 4))
+		case rsp.getComponent(xtn, 3) != "Internet" && pat.Phone == "":
+			pat.Phone = rsp.unescape(rsp.getComponent(xtn, 1))
+		}
+	}
+	pat.MaritalStatus = rsp.getComponent(getHL7v2Field(pid, 16), 1)
+	pat.MultipleBirth = getHL7v2Field(pid, 24) == "Y"
+	pat.Deceased = getHL7v2Field(pid, 30) == "Y"
+	return pat
+}
+
+// getPDQv2QueryParameters returns the QPD-3 query parameters for the pdq Used_PID and any FamilyName, GivenName, BirthDate, Gender and Zip search values set
+func getPDQv2QueryParameters(i *PDQQuery) string {
+	params := []string{}
+	if i.Used_PID != "" {
+		params = append(params, "@PID.3.1^"+escapeHL7v2(i.Used_PID), "@PID.3.4.2^"+escapeHL7v2(i.Used_PID_OID), "@PID.3.4.3^ISO")
+	}
+	if i.FamilyName != "" {
+		params = append(params, "@PID.5.1.1^"+escapeHL7v2(i.FamilyName))
+	}
+	if i.GivenName != "" {
+		params = append(params, "@PID.5.2^"+escapeHL7v2(i.GivenName))
+	}
+	if i.BirthDate != "" {
+		params = append(params, "@PID.7.1^"+escapeHL7v2(getHL7Date(i.BirthDate)))
+	}
+	if i.Gender != "" {
+		params = append(params, "@PID.8^"+getHL7v2Gender(i.Gender))
+	}
+	if i.Zip != "" {
+		params = append(params, "@PID.11.5^"+escapeHL7v2(i.Zip))
+	}
+	return strings.Join(params, "~")
+}
+
+// getHL7v2Gender returns the hl7 v2 administrative sex code for a fhir administrative gender or hl7 gender code
+func getHL7v2Gender(gender string) string {
+	if code := getHL7Gender(gender); code != "UN" {
+		return code
+	}
+	return "O"
+}
+
+// escapeHL7v2 returns val with the default HL7 v2 delimiters replaced by their escape sequences
+func escapeHL7v2(val string) string {
+	return strings.NewReplacer("\\", "\\E\\", "|", "\\F\\", "^", "\\S\\", "&", "\\T\\", "~", "\\R\\", "\r", " ", "\n", " ").Replace(val)
+}
+
+// unescape returns val with the HL7 v2 delimiter escape sequences replaced by the delimiters
+func (i *HL7v2Response) unescape(val string) string {
+	if !strings.Contains(val, i.encoding.escape) {
+		return val
+	}
+	e := i.encoding.escape
+	return strings.NewReplacer(e+"F"+e, i.encoding.field, e+"S"+e, i.encoding.component, e+"T"+e, i.encoding.subcomponent, e+"R"+e, i.encoding.repetition, e+"E"+e, e).Replace(val)
+}
+func (i *HL7v2Response) getRepetitions(field string) []string {
+	if field == "" {
+		return nil
+	}
+	return strings.Split(field, i.encoding.repetition)
+}
+func (i *HL7v2Response) getComponent(field string, n int) string {
+	return getHL7v2Field(strings.Split(field, i.encoding.component), n-1)
+}
+func (i *HL7v2Response) getSubComponent(component string, n int) string {
+	return getHL7v2Field(strings.Split(component, i.encoding.subcomponent), n-1)
+}
+
+// getComponents returns the components from and to of field joined with ^
+func (i *HL7v2Response) getComponents(field string, from int, to int) string {
+	vals := []string{}
+	for n := from; n <= to; n++ {
+		if val := i.getComponent(field, n); val != "" {
+			vals = append(vals, val)
+		}
+	}
+	return strings.Join(vals, "^")
+}
+
+// getHL7v2Field returns fields[n] or an empty string if the segment has no field n
+func getHL7v2Field(fields []string, n int) string {
+	if n < 0 || n >= len(fields) {
+		return ""
+	}
+	return fields[n]
+}
+
+// getHL7v2ControlID returns the MSH-10 message control id of the HL7 v2 message
+func getHL7v2ControlID(msg []byte) string {
+	seg, _, _ := strings.Cut(string(msg), "\r")
+	if !strings.HasPrefix(seg, "MSH") || len(seg) < 4 {
+		return ""
+	}
+	// MSH-1 is the field separator so MSH-n is fields[n-1]
+	return getHL7v2Field(strings.Split(seg, seg[3:4]), 9)
+}
+
+// hl7v2Printable returns msg with the segment terminators replaced by new lines for logging
+func hl7v2Printable(msg []byte) string {
+	return strings.ReplaceAll(string(msg), "\r", "\n")
+}
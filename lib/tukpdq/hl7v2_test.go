@@ -0,0 +1,247 @@
+package tukpdq
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+const (
+	testREGOID = "2.16.840.1.113883.2.1.3.31.2.1.1"
+	testNHSOID = "2.16.840.1.113883.2.1.4.1"
+	testMRNOID = "1.2.840.114350.1.13.28.1.18.5.999"
+)
+
+func TestNewHL7v2PatientIdentifiers(t *testing.T) {
+	pid3 := "9999999468^^^NHS&" + testNHSOID + "&ISO~MRN123^^^HOSP&" + testMRNOID + "&ISO~REG.1^^^REG&" + testREGOID + "&ISO"
+	twolocal := pid3 + "~LAB9^^^LAB&1.2.3.9&ISO"
+	tests := []struct {
+		name       string
+		pid3       string
+		mrnoid     string
+		wantPID    string
+		wantPIDOID string
+	}{
+		{"mrn oid set", pid3, testMRNOID, "MRN123", testMRNOID},
+		{"mrn oid not set returns the local id", pid3, "", "MRN123", testMRNOID},
+		{"mrn oid of another domain", pid3, "1.2.3.4", "", ""},
+		{"mrn oid not set returns the first of two local ids", twolocal, "", "MRN123", testMRNOID},
+		{"mrn oid set returns the mrn domain id", twolocal, "1.2.3.9", "LAB9", "1.2.3.9"},
+	}
+	for _, tt := range tests {
+		pdq := PDQQuery{REG_OID: testREGOID, NHS_OID: testNHSOID, MRN_OID: tt.mrnoid, Message_ID: "1", Response: []byte("MSH|^~\\&|PIX|PIX|TUK|TUK|20230101||RSP^K23^RSP_K23|1|P|2.5\rMSA|AA|1\rQAK|1|OK\rPID|||" + tt.pid3)}
+		if err := pdq.setHL7v2Response(); err != nil {
+			t.Fatal(err)
+		}
+		pat := pdq.newHL7v2Patient(pdq.HL7v2Response.PID[0])
+		if pat.NHSID != "9999999468" || pat.REGID != "REG.1" {
+			t.Errorf("%s: NHSID = %q REGID = %q, want 9999999468 and REG.1", tt.name, pat.NHSID, pat.REGID)
+		}
+		if pat.PID != tt.wantPID || pat.PIDOID != tt.wantPIDOID {
+			t.Errorf("%s: PID = %q %q, want %q %q", tt.name, pat.PID, pat.PIDOID, tt.wantPID, tt.wantPIDOID)
+		}
+	}
+}
+
+// newMLLPServer starts a local MLLP stand-in listener that returns rsp, wrapped in a MLLP frame, to each request, or, if rsp is empty, never replies. {{MSH10}} in rsp is replaced by the MSH-10 message control id of the request.
+// The raw request frames received are sent to the returned channel
+func newMLLPServer(t *testing.T, rsp string) (string, chan []byte) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	frames := make(chan []byte, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				frame, err := r.ReadBytes(mllpEndBlock)
+				if err != nil {
+					return
+				}
+				// the frame ends with the end block and a carriage return
+				if cr, err := r.ReadByte(); err == nil {
+					frame = append(frame, cr)
+				}
+				frames <- frame
+				if rsp == "" {
+					time.Sleep(3 * time.Second)
+					return
+				}
+				msg := strings.ReplaceAll(rsp, "{{MSH10}}", getHL7v2ControlID(frame[1:]))
+				conn.Write(append(append([]byte{mllpStartBlock}, msg...), mllpEndBlock, mllpCR))
+			}(conn)
+		}
+	}()
+	return mllpScheme + ln.Addr().String(), frames
+}
+
+func newTestPDQv2Query(mode string, url string) *PDQQuery {
+	return &PDQQuery{
+		Server_Mode: mode,
+		Server_URL:  url,
+		NHS_ID:      "9999999468",
+		REG_OID:     testREGOID,
+		Timeout:     1,
+	}
+}
+
+func TestMLLPFraming(t *testing.T) {
+	rsp := "MSH|^~\\&|PDQ|PDQ|TUK|TUK|20230101||RSP^K22^RSP_K21|1|P|2.5\rMSA|AA|{{MSH10}}\rQAK|1|OK\rPID|||9999999468^^^NHS&" + testNHSOID + "&ISO||Testpatient^Nhs"
+	url, frames := newMLLPServer(t, rsp)
+	pdq := newTestPDQv2Query(tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2, url)
+	if err := New_Transaction(pdq); err != nil {
+		t.Fatal(err)
+	}
+	frame := <-frames
+	if frame[0] != mllpStartBlock || !bytes.HasSuffix(frame, []byte{mllpEndBlock, mllpCR}) {
+		t.Errorf("request is not wrapped in a mllp frame - %q", frame)
+	}
+	if msg := frame[1 : len(frame)-2]; !bytes.Equal(msg, pdq.Request) || !bytes.HasPrefix(msg, []byte("MSH|^~\\&|")) || !bytes.Contains(msg, []byte("QBP^Q22")) {
+		t.Errorf("framed request is not the QBP^Q22 message - %q", msg)
+	}
+	if want := strings.ReplaceAll(rsp, "{{MSH10}}", pdq.Message_ID); string(pdq.Response) != want {
+		t.Errorf("Response = %q, want the unwrapped response message %q", pdq.Response, want)
+	}
+}
+
+func TestPDQv2MultiplePatients(t *testing.T) {
+	rsp := "MSH|^~\\&|PDQ|PDQ|TUK|TUK|20230101||RSP^K22^RSP_K21|1|P|2.5\rMSA|AA|{{MSH10}}\rQAK|1|OK|2\r" +
+		"PID|1||9999999468^^^NHS&" + testNHSOID + "&ISO~REG.1^^^REG&" + testREGOID + "&ISO||Testpatient^Nhs^A||19620404|M|||1 Preston Road^Fulwood^Preston^^PR1 1PR^GBR\r" +
+		"PID|2||9999999484^^^NHS&" + testNHSOID + "&ISO||O\\S\\Brien^Mary||19700101|F"
+	url, _ := newMLLPServer(t, rsp)
+	pdq := newTestPDQv2Query(tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2, url)
+	if err := New_Transaction(pdq); err != nil {
+		t.Fatal(err)
+	}
+	if pdq.Count != 2 {
+		t.Fatalf("Count = %v, want 2", pdq.Count)
+	}
+	pats := *pdq.Patients
+	want := []TUKPatient{
+		{NHSID: "9999999468", REGID: "REG.1", FamilyName: "Testpatient", GivenName: "Nhs A", BirthDate: "19620404", Gender: "male", Street: "1 Preston Road", Town: "Fulwood", City: "Preston", Zip: "PR1 1PR", Country: "GBR"},
+		{NHSID: "9999999484", FamilyName: "O^Brien", GivenName: "Mary", BirthDate: "19700101", Gender: "female"},
+	}
+	for n, w := range want {
+		p := pats[n]
+		if p.NHSID != w.NHSID || p.REGID != w.REGID || p.FamilyName != w.FamilyName || p.GivenName != w.GivenName || p.BirthDate != w.BirthDate || p.Gender != w.Gender ||
+			p.Street != w.Street || p.Town != w.Town || p.City != w.City || p.Zip != w.Zip || p.Country != w.Country {
+			t.Errorf("patient %v = %+v, want %+v", n, p, w)
+		}
+	}
+}
+
+func TestHL7v2AckErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		rsp        string
+		wantCode   string
+		wantDetail string
+		notFound   bool
+	}{
+		{
+			name:       "pdqv2 AE with ERR",
+			mode:       tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2,
+			rsp:        "MSH|^~\\&|PDQ|PDQ|TUK|TUK|20230101||RSP^K22^RSP_K21|1|P|2.5\rMSA|AE|{{MSH10}}|Query rejected\rERR|||207^Application internal error^HL70357|E||||Database \\F\\ unavailable\rQAK|1|AE",
+			wantCode:   "AE",
+			wantDetail: "Query rejected - 207^Application internal error Database | unavailable",
+		},
+		{
+			name:       "pixv2 AR with ERR",
+			mode:       tukcnst.PDQ_SERVER_TYPE_IHE_PIXV2,
+			rsp:        "MSH|^~\\&|PIX|PIX|TUK|TUK|20230101||RSP^K23^RSP_K23|1|P|2.5\rMSA|AR|{{MSH10}}\rERR|||200^Unsupported message type^HL70357|E\rQAK|1|AR",
+			wantCode:   "AR",
+			wantDetail: "200^Unsupported message type",
+		},
+		{
+			name:     "pixv2 AE unknown key identifier is not found",
+			mode:     tukcnst.PDQ_SERVER_TYPE_IHE_PIXV2,
+			rsp:      "MSH|^~\\&|PIX|PIX|TUK|TUK|20230101||RSP^K23^RSP_K23|1|P|2.5\rMSA|AE|{{MSH10}}\rERR||QPD^1^3^1^1|204^Unknown Key Identifier^HL70357|E\rQAK|1|AE",
+			notFound: true,
+		},
+	}
+	for _, tt := range tests {
+		url, _ := newMLLPServer(t, tt.rsp)
+		err := New_Transaction(newTestPDQv2Query(tt.mode, url))
+		if tt.notFound {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: err = %v, want ErrNotFound", tt.name, err)
+			}
+			continue
+		}
+		var ackerr *AckError
+		if !errors.As(err, &ackerr) {
+			t.Errorf("%s: err = %v, want AckError", tt.name, err)
+			continue
+		}
+		if ackerr.Code != tt.wantCode || ackerr.Detail != tt.wantDetail {
+			t.Errorf("%s: AckError = %q %q, want %q %q", tt.name, ackerr.Code, ackerr.Detail, tt.wantCode, tt.wantDetail)
+		}
+	}
+}
+
+func TestHL7v2AckCorrelation(t *testing.T) {
+	tests := []struct {
+		name          string
+		msa           string
+		wantRelatesTo string
+	}{
+		{"msa-2 of another message", "MSA|AA|5f1c2e9a-0000-4000-8000-000000000000\r", "5f1c2e9a-0000-4000-8000-000000000000"},
+		{"no msa segment", "", ""},
+	}
+	for _, tt := range tests {
+		url, _ := newMLLPServer(t, "MSH|^~\\&|PDQ|PDQ|TUK|TUK|20230101||RSP^K22^RSP_K21|1|P|2.5\r"+tt.msa+"QAK|1|OK\rPID|||9999999468^^^NHS&"+testNHSOID+"&ISO||Testpatient^Nhs")
+		pdq := newTestPDQv2Query(tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2, url)
+		err := New_Transaction(pdq)
+		var correrr *CorrelationError
+		if !errors.As(err, &correrr) {
+			t.Errorf("%s: err = %v, want CorrelationError", tt.name, err)
+			continue
+		}
+		if correrr.Message_ID == "" || correrr.Message_ID != getHL7v2ControlID(pdq.Request) || correrr.RelatesTo != tt.wantRelatesTo {
+			t.Errorf("%s: CorrelationError = %+v, want the request MSH-10 %q and RelatesTo %q", tt.name, correrr, getHL7v2ControlID(pdq.Request), tt.wantRelatesTo)
+		}
+	}
+}
+
+func TestMLLPTimeout(t *testing.T) {
+	url, _ := newMLLPServer(t, "")
+	start := time.Now()
+	err := New_Transaction(newTestPDQv2Query(tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2, url))
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("request took %v, want the 1 second Timeout", elapsed)
+	}
+}
+
+func TestHL7v2CustomEncoding(t *testing.T) {
+	rsp := "MSH#$*/%#PDQ#PDQ#TUK#TUK#20230101##RSP$K22$RSP_K21#1#P#2.5\rMSA#AA#{{MSH10}}\rQAK#1#OK\r" +
+		"PID#1##9999999468$$$NHS%" + testNHSOID + "%ISO*REG.1$$$REG%" + testREGOID + "%ISO##Smith/F/Jones$Ann##19800101#F"
+	url, _ := newMLLPServer(t, rsp)
+	pdq := newTestPDQv2Query(tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2, url)
+	if err := New_Transaction(pdq); err != nil {
+		t.Fatal(err)
+	}
+	if pdq.HL7v2Response.MessageType != "RSP^K22" {
+		t.Errorf("MessageType = %q, want RSP^K22", pdq.HL7v2Response.MessageType)
+	}
+	pat := (*pdq.Patients)[0]
+	if pat.NHSID != "9999999468" || pat.REGID != "REG.1" || pat.FamilyName != "Smith#Jones" || pat.GivenName != "Ann" || pat.Gender != "female" {
+		t.Errorf("patient = %+v, want 9999999468 REG.1 Smith#Jones Ann female", pat)
+	}
+}
@@ -0,0 +1,364 @@
+package tukpdq
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipthomas/tukhttp"
+)
+
+// KVStore is a key value table used by a KVCache to hold the cached responses for each patient. GetItem returns false if there is no item for the key or the item has expired.
+// A request to a remote store is cancelled if ctx is cancelled or reaches its deadline
+type KVStore interface {
+	GetItem(ctx context.Context, key string) ([]byte, bool, error)
+	PutItem(ctx context.Context, key string, value []byte, expires time.Time) error
+	DeleteItem(ctx context.Context, key string) error
+}
+
+// KVCache is a patient cache held in a KVStore, so cached responses survive Lambda cold starts and are shared by concurrent Lambda instances.
+// Each response is held in its own item, keyed by a sha256 hash of the patient generation, id, oid and server, which expires when the entry can no longer be used. Concurrent Sets never overwrite each other's responses.
+//
+// If Encryption_Key is set (16, 24 or 32 bytes) the items are encrypted with AES-GCM before they are stored. Stats are counted per KVCache and Entries is not reported
+type KVCache struct {
+	CachePolicy
+	Store          KVStore
+	Encryption_Key []byte
+	mu             sync.Mutex
+	stats          CacheStats
+}
+
+// FileStore is a KVStore holding each item in a file in Dir. Expired items are removed when they are read and when the FileStore is created
+type FileStore struct {
+	Dir string
+}
+type fileStoreItem struct {
+	Value   []byte `json:"value"`
+	Expires int64  `json:"expires"`
+}
+
+// DynamoDBStore is a KVStore holding each item in a DynamoDB Table with a string partition key named Key. The Expires attribute holds the item expiry time in unix seconds and can be set as the table TTL attribute.
+// URL is the DynamoDB endpoint and defaults to https://dynamodb.{Region}.amazonaws.com. Set URL to a DynamoDB Local endpoint (eg http://localhost:8000) for testing
+type DynamoDBStore struct {
+	URL     string
+	Region  string
+	Table   string
+	Timeout int64
+}
+type dynamoDBAttribute struct {
+	S string `json:"S,omitempty"`
+	B []byte `json:"B,omitempty"`
+	N string `json:"N,omitempty"`
+}
+
+// NewKVCache returns a KVCache using the store. A policy TTL of 0 or less is set to the default of 15 minutes. An encryption key is optional
+func NewKVCache(store KVStore, policy CachePolicy, key []byte) (*KVCache, error) {
+	if store == nil {
+		return nil, errors.New("kv cache store is not set")
+	}
+	if len(key) > 0 {
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, err
+		}
+	}
+	return &KVCache{CachePolicy: policy.withDefaults(), Store: store, Encryption_Key: key}, nil
+}
+
+// NewFileStore returns a FileStore using dir, creating dir if it does not exist and removing any expired items
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	store := FileStore{Dir: dir}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		store.GetItem(context.Background(), file.Name())
+	}
+	return &store, nil
+}
+
+// Get returns the cached entry for the key. A store error is logged and counted as a miss
+func (c *KVCache) Get(ctx context.Context, key CacheKey) (CacheEntry, bool) {
+	entry, ok, err := c.getEntry(ctx, key)
+	if err != nil {
+		log.Printf("Unable to read patient cache - %s", err.Error())
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ok {
+		c.stats.count(entry)
+		return entry, true
+	}
+	c.stats.Misses++
+	return CacheEntry{}, false
+}
+
+// Set caches the entry for the key in its own item, so concurrent Sets for other servers of the same patient do not overwrite it. A store error is logged
+func (c *KVCache) Set(ctx context.Context, key CacheKey, entry CacheEntry) {
+	if !c.caches(entry) {
+		return
+	}
+	if err := c.putEntry(ctx, key, entry); err != nil {
+		log.Printf("Unable to write patient cache - %s", err.Error())
+	}
+}
+
+// Delete removes the cached response for the key
+func (c *KVCache) Delete(ctx context.Context, key CacheKey) {
+	itemKey, err := c.entryItemKey(ctx, key)
+	if err == nil {
+		err = c.Store.DeleteItem(ctx, itemKey)
+	}
+	if err != nil {
+		log.Printf("Unable to delete patient cache entry - %s", err.Error())
+	}
+}
+
+// DeletePatient removes the cached responses from every server for the patient id and oid by replacing the patient generation, so the existing entries are no longer found and expire from the store.
+// The generation item is kept until every entry cached before it was replaced has expired
+func (c *KVCache) DeletePatient(ctx context.Context, pid string, oid string) {
+	if pid == "" {
+		return
+	}
+	generation := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, generation); err != nil {
+		log.Printf("Unable to delete patient cache entry - %s", err.Error())
+		return
+	}
+	lifetime := c.TTL
+	if c.Not_Found_TTL > lifetime {
+		lifetime = c.Not_Found_TTL
+	}
+	if err := c.Store.PutItem(ctx, kvItemKey(pid, oid), []byte(hex.EncodeToString(generation)), time.Now().Add(lifetime+c.Stale_TTL)); err != nil {
+		log.Printf("Unable to delete patient cache entry - %s", err.Error())
+	}
+}
+
+// Stats returns the hit, miss and stale counts of the KVCache
+func (c *KVCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// getEntry returns the cache entry for the key if it is usable
+func (c *KVCache) getEntry(ctx context.Context, key CacheKey) (CacheEntry, bool, error) {
+	entry := CacheEntry{}
+	itemKey, err := c.entryItemKey(ctx, key)
+	if err != nil {
+		return entry, false, err
+	}
+	value, ok, err := c.Store.GetItem(ctx, itemKey)
+	if err != nil || !ok {
+		return entry, false, err
+	}
+	if value, err = c.decrypt(value); err != nil {
+		return entry, false, err
+	}
+	if err = json.Unmarshal(value, &entry); err != nil {
+		return CacheEntry{}, false, err
+	}
+	entry, ok = c.check(entry)
+	return entry, ok, nil
+}
+func (c *KVCache) putEntry(ctx context.Context, key CacheKey, entry CacheEntry) error {
+	itemKey, err := c.entryItemKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if value, err = c.encrypt(value); err != nil {
+		return err
+	}
+	return c.Store.PutItem(ctx, itemKey, value, c.expires(entry).Add(c.Stale_TTL))
+}
+
+// entryItemKey returns the hex encoded sha256 hash of the current patient generation, the patient id and oid and the entry key. The generation is empty until DeletePatient is first called for the patient
+func (c *KVCache) entryItemKey(ctx context.Context, key CacheKey) (string, error) {
+	generation, _, err := c.Store.GetItem(ctx, kvItemKey(key.PID, key.PID_OID))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(string(generation) + "|" + key.PID_OID + "|" + key.PID + "|" + key.entryKey()))
+	return hex.EncodeToString(sum[:]), nil
+}
+func (c *KVCache) encrypt(value []byte) ([]byte, error) {
+	if len(c.Encryption_Key) == 0 {
+		return value, nil
+	}
+	gcm, err := c.newGCM()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, value, nil), nil
+}
+func (c *KVCache) decrypt(value []byte) ([]byte, error) {
+	if len(c.Encryption_Key) == 0 {
+		return value, nil
+	}
+	gcm, err := c.newGCM()
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < gcm.NonceSize() {
+		return nil, errors.New("encrypted patient cache item is too short")
+	}
+	return gcm.Open(nil, value[:gcm.NonceSize()], value[gcm.NonceSize():], nil)
+}
+func (c *KVCache) newGCM() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.Encryption_Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// kvItemKey returns the key of the patient generation item, the hex encoded sha256 hash of the patient id and oid, so patient ids are not stored in plain text as item keys or file names
+func kvItemKey(pid string, oid string) string {
+	sum := sha256.Sum256([]byte(oid + "|" + pid))
+	return hex.EncodeToString(sum[:])
+}
+
+// entryKey returns the server key of the cached response, which is hashed into the KVCache item key
+func (i CacheKey) entryKey() string {
+	if i.Target_Systems != "" {
+		return i.Server_Mode + "|" + i.Server_URL + "|" + i.Target_Systems
+	}
+	return i.Server_Mode + "|" + i.Server_URL
+}
+
+// GetItem returns the item in the file named key. The FileStore reads and writes local files so ctx is not used
+func (i *FileStore) GetItem(ctx context.Context, key string) ([]byte, bool, error) {
+	b, err := os.ReadFile(filepath.Join(i.Dir, key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	item := fileStoreItem{}
+	if err = json.Unmarshal(b, &item); err != nil || time.Now().Unix() >= item.Expires {
+		os.Remove(filepath.Join(i.Dir, key))
+		return nil, false, err
+	}
+	return item.Value, true, nil
+}
+
+// PutItem writes the item to a temporary file which is then renamed, so a concurrent GetItem never reads a partly written item
+func (i *FileStore) PutItem(ctx context.Context, key string, value []byte, expires time.Time) error {
+	b, err := json.Marshal(fileStoreItem{Value: value, Expires: expires.Unix()})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(i.Dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(i.Dir, key))
+}
+func (i *FileStore) DeleteItem(ctx context.Context, key string) error {
+	if err := os.Remove(filepath.Join(i.Dir, key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (i *DynamoDBStore) GetItem(ctx context.Context, key string) ([]byte, bool, error) {
+	rsp := struct {
+		Item map[string]dynamoDBAttribute
+	}{}
+	if err := i.newRequest(ctx, "GetItem", map[string]interface{}{
+		"TableName":      i.Table,
+		"Key":            map[string]dynamoDBAttribute{"Key": {S: key}},
+		"ConsistentRead": true,
+	}, &rsp); err != nil {
+		return nil, false, err
+	}
+	if rsp.Item == nil {
+		return nil, false, nil
+	}
+	if expires, _ := strconv.ParseInt(rsp.Item["Expires"].N, 10, 64); time.Now().Unix() >= expires {
+		return nil, false, nil
+	}
+	return rsp.Item["Value"].B, true, nil
+}
+func (i *DynamoDBStore) PutItem(ctx context.Context, key string, value []byte, expires time.Time) error {
+	return i.newRequest(ctx, "PutItem", map[string]interface{}{
+		"TableName": i.Table,
+		"Item": map[string]dynamoDBAttribute{
+			"Key":     {S: key},
+			"Value":   {B: value},
+			"Expires": {N: strconv.FormatInt(expires.Unix(), 10)},
+		},
+	}, nil)
+}
+func (i *DynamoDBStore) DeleteItem(ctx context.Context, key string) error {
+	return i.newRequest(ctx, "DeleteItem", map[string]interface{}{
+		"TableName": i.Table,
+		"Key":       map[string]dynamoDBAttribute{"Key": {S: key}},
+	}, nil)
+}
+
+// newRequest sends the DynamoDB action using ctx and unmarshals the response into rsp if rsp is not nil
+func (i *DynamoDBStore) newRequest(ctx context.Context, action string, body interface{}, rsp interface{}) error {
+	if i.Table == "" {
+		return errors.New("dynamodb patient cache table is not set")
+	}
+	// the store is shared by concurrent queries, so the default endpoint is not saved in URL
+	endpoint := i.URL
+	if endpoint == "" {
+		endpoint = "https://dynamodb." + i.Region + ".amazonaws.com"
+	}
+	httpReq := tukhttp.DynamoDBRequest{
+		URL:     endpoint,
+		Region:  i.Region,
+		Action:  action,
+		Timeout: i.Timeout,
+	}
+	var err error
+	if httpReq.Body, err = json.Marshal(body); err != nil {
+		return err
+	}
+	if err = tukhttp.NewRequestWithContext(ctx, &httpReq); err != nil {
+		return err
+	}
+	if httpReq.StatusCode != http.StatusOK {
+		return fmt.Errorf("dynamodb %s returned http status %v %s", action, httpReq.StatusCode, string(httpReq.Response))
+	}
+	if rsp == nil {
+		return nil
+	}
+	return json.Unmarshal(httpReq.Response, rsp)
+}
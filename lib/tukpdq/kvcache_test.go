@@ -0,0 +1,280 @@
+package tukpdq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+var testCacheKey = CacheKey{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, Server_URL: "https://pdq.example.org/pdq", PID: "9999999468", PID_OID: testNHSOID}
+
+// dynamoDBStandIn is a local stand-in for the DynamoDB GetItem, PutItem and DeleteItem actions, holding the items in memory and recording the requests received
+type dynamoDBStandIn struct {
+	mu       sync.Mutex
+	items    map[string]map[string]dynamoDBAttribute
+	requests []dynamoDBStandInRequest
+}
+type dynamoDBStandInRequest struct {
+	Target        string
+	Authorization string
+	Body          map[string]json.RawMessage
+}
+
+func newDynamoDBStandIn(t *testing.T) (*dynamoDBStandIn, *httptest.Server) {
+	db := dynamoDBStandIn{items: make(map[string]map[string]dynamoDBAttribute)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		req := dynamoDBStandInRequest{Target: r.Header.Get("X-Amz-Target"), Authorization: r.Header.Get("Authorization")}
+		json.Unmarshal(b, &req.Body)
+		body := struct {
+			TableName string
+			Key       map[string]dynamoDBAttribute
+			Item      map[string]dynamoDBAttribute
+		}{}
+		json.Unmarshal(b, &body)
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		db.requests = append(db.requests, req)
+		if body.TableName != "tuk-patient-cache" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException"}`))
+			return
+		}
+		switch strings.TrimPrefix(req.Target, "DynamoDB_20120810.") {
+		case "GetItem":
+			if item, ok := db.items[body.Key["Key"].S]; ok {
+				json.NewEncoder(w).Encode(map[string]interface{}{"Item": item})
+				return
+			}
+			w.Write([]byte(`{}`))
+		case "PutItem":
+			db.items[body.Item["Key"].S] = body.Item
+			w.Write([]byte(`{}`))
+		case "DeleteItem":
+			delete(db.items, body.Key["Key"].S)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv(tukcnst.ENV_AWS_ACCESS_KEY_ID, "AKIDEXAMPLE")
+	t.Setenv(tukcnst.ENV_AWS_SECRET_ACCESS_KEY, "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	return &db, srv
+}
+
+func newTestKVCache(t *testing.T, store KVStore, key []byte) *KVCache {
+	cache, err := NewKVCache(store, CachePolicy{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+// testKVCacheStore checks the cache returns, deletes and expires entries
+func testKVCacheStore(t *testing.T, cache *KVCache) {
+	response := []byte(`<PRPA_IN201306UV02>9999999468</PRPA_IN201306UV02>`)
+	if _, ok := cache.Get(context.Background(), testCacheKey); ok {
+		t.Fatal("empty cache returned an entry")
+	}
+	cache.Set(context.Background(), testCacheKey, CacheEntry{Response: response, Created: time.Now()})
+	notfound := testCacheKey
+	notfound.Server_Mode = tukcnst.PDQ_SERVER_TYPE_IHE_PDQM
+	cache.Set(context.Background(), notfound, CacheEntry{Not_Found: true, Created: time.Now()})
+	entry, ok := cache.Get(context.Background(), testCacheKey)
+	if !ok || !bytes.Equal(entry.Response, response) || entry.Stale {
+		t.Fatalf("Get = %+v %v, want the cached response", entry, ok)
+	}
+	if entry, ok = cache.Get(context.Background(), notfound); !ok || !entry.Not_Found {
+		t.Fatalf("Get not found entry = %+v %v, want the Not_Found entry", entry, ok)
+	}
+	cache.Delete(context.Background(), notfound)
+	if _, ok = cache.Get(context.Background(), notfound); ok {
+		t.Error("deleted entry was returned")
+	}
+	if _, ok = cache.Get(context.Background(), testCacheKey); !ok {
+		t.Error("entry for another server was deleted")
+	}
+	cache.DeletePatient(context.Background(), testCacheKey.PID, testCacheKey.PID_OID)
+	if _, ok = cache.Get(context.Background(), testCacheKey); ok {
+		t.Error("entry for deleted patient was returned")
+	}
+	cache.Set(context.Background(), testCacheKey, CacheEntry{Response: response, Created: time.Now().Add(-cache.TTL - time.Second)})
+	if _, ok = cache.Get(context.Background(), testCacheKey); ok {
+		t.Error("expired entry was returned")
+	}
+	if stats := cache.Stats(); stats.Hits != 3 || stats.Misses != 4 {
+		t.Errorf("Stats = %+v, want 3 hits and 4 misses", stats)
+	}
+}
+
+// testKVCacheConcurrentSets checks concurrent Sets for different servers of the same patient all remain cached
+func testKVCacheConcurrentSets(t *testing.T, cache *KVCache) {
+	var wg sync.WaitGroup
+	for n := 0; n < 20; n++ {
+		key := testCacheKey
+		key.Server_URL = fmt.Sprintf("https://pdq%d.test", n)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Set(context.Background(), key, CacheEntry{Response: []byte(key.Server_URL), Created: time.Now()})
+		}()
+	}
+	wg.Wait()
+	for n := 0; n < 20; n++ {
+		key := testCacheKey
+		key.Server_URL = fmt.Sprintf("https://pdq%d.test", n)
+		if entry, ok := cache.Get(context.Background(), key); !ok || string(entry.Response) != key.Server_URL {
+			t.Errorf("Get %s = %q %v, want the cached response", key.Server_URL, entry.Response, ok)
+		}
+	}
+}
+
+func TestKVCacheFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKVCacheStore(t, newTestKVCache(t, store, nil))
+	testKVCacheConcurrentSets(t, newTestKVCache(t, store, nil))
+}
+
+func TestKVCacheDynamoDBStore(t *testing.T) {
+	_, srv := newDynamoDBStandIn(t)
+	store := DynamoDBStore{URL: srv.URL, Region: "eu-west-2", Table: "tuk-patient-cache"}
+	testKVCacheStore(t, newTestKVCache(t, &store, nil))
+	testKVCacheConcurrentSets(t, newTestKVCache(t, &store, nil))
+}
+
+func TestFileStoreRemovesExpiredItems(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStore(dir)
+	store.PutItem(context.Background(), "expired", []byte("a"), time.Now().Add(-time.Second))
+	store.PutItem(context.Background(), "current", []byte("b"), time.Now().Add(time.Minute))
+	if _, err := NewFileStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "expired")); !os.IsNotExist(err) {
+		t.Error("expired item file was not removed")
+	}
+	if value, ok, err := store.GetItem(context.Background(), "current"); !ok || err != nil || string(value) != "b" {
+		t.Errorf("GetItem = %q %v %v, want b", value, ok, err)
+	}
+}
+
+func TestDynamoDBStoreWireFormat(t *testing.T) {
+	db, srv := newDynamoDBStandIn(t)
+	store := DynamoDBStore{URL: srv.URL, Region: "eu-west-2", Table: "tuk-patient-cache"}
+	expires := time.Now().Add(time.Minute)
+	if err := store.PutItem(context.Background(), "key1", []byte("value1"), expires); err != nil {
+		t.Fatal(err)
+	}
+	value, ok, err := store.GetItem(context.Background(), "key1")
+	if err != nil || !ok || string(value) != "value1" {
+		t.Fatalf("GetItem = %q %v %v, want value1", value, ok, err)
+	}
+	if err = store.DeleteItem(context.Background(), "key1"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target string
+		body   map[string]string
+	}{
+		{"DynamoDB_20120810.PutItem", map[string]string{
+			"TableName": `"tuk-patient-cache"`,
+			"Item":      `{"Expires":{"N":"` + strconv.FormatInt(expires.Unix(), 10) + `"},"Key":{"S":"key1"},"Value":{"B":"dmFsdWUx"}}`,
+		}},
+		{"DynamoDB_20120810.GetItem", map[string]string{
+			"TableName":      `"tuk-patient-cache"`,
+			"Key":            `{"Key":{"S":"key1"}}`,
+			"ConsistentRead": `true`,
+		}},
+		{"DynamoDB_20120810.DeleteItem", map[string]string{
+			"TableName": `"tuk-patient-cache"`,
+			"Key":       `{"Key":{"S":"key1"}}`,
+		}},
+	}
+	if len(db.requests) != len(tests) {
+		t.Fatalf("%v requests received, want %v", len(db.requests), len(tests))
+	}
+	for n, tt := range tests {
+		req := db.requests[n]
+		if req.Target != tt.target {
+			t.Errorf("request %v X-Amz-Target = %q, want %q", n, req.Target, tt.target)
+		}
+		if !strings.HasPrefix(req.Authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(req.Authorization, "/eu-west-2/dynamodb/aws4_request") {
+			t.Errorf("request %v is not signed with AWS Signature Version 4 - %q", n, req.Authorization)
+		}
+		if len(req.Body) != len(tt.body) {
+			t.Errorf("request %v body = %v, want %v", n, req.Body, tt.body)
+		}
+		for name, want := range tt.body {
+			if got := string(req.Body[name]); got != want {
+				t.Errorf("request %v %s = %s, want %s", n, name, got, want)
+			}
+		}
+	}
+	store.Table = "unknown"
+	if _, _, err = store.GetItem(context.Background(), "key1"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("GetItem from unknown table err = %v, want http status 400 error", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	requests := len(db.requests)
+	if _, _, err = store.GetItem(ctx, "key1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetItem with a cancelled context err = %v, want %v", err, context.Canceled)
+	}
+	if len(db.requests) != requests {
+		t.Error("request was sent with a cancelled context")
+	}
+}
+
+func TestKVCacheEncryption(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStore(dir)
+	key := bytes.Repeat([]byte{7}, 32)
+	cache := newTestKVCache(t, store, key)
+	response := []byte(`<given>Nhs</given><family>Testpatient</family>`)
+	cache.Set(context.Background(), testCacheKey, CacheEntry{Response: response, Created: time.Now()})
+	if entry, ok := cache.Get(context.Background(), testCacheKey); !ok || !bytes.Equal(entry.Response, response) {
+		t.Fatalf("Get = %q %v, want the decrypted response", entry.Response, ok)
+	}
+	itemKey, _ := cache.entryItemKey(context.Background(), testCacheKey)
+	value, _, _ := store.GetItem(context.Background(), itemKey)
+	if bytes.Contains(value, []byte("Testpatient")) || bytes.Contains(value, []byte(testCacheKey.PID)) {
+		t.Error("stored item is not encrypted")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || strings.Contains(entries[0].Name(), testCacheKey.PID) {
+		t.Errorf("item file name is not the hashed patient id - %v", entries)
+	}
+	if _, ok := newTestKVCache(t, store, bytes.Repeat([]byte{8}, 32)).Get(context.Background(), testCacheKey); ok {
+		t.Error("item was decrypted with a different key")
+	}
+	tampered := append([]byte{}, value...)
+	tampered[len(tampered)-1] ^= 0x01
+	store.PutItem(context.Background(), itemKey, tampered, time.Now().Add(time.Minute))
+	if _, ok := cache.Get(context.Background(), testCacheKey); ok {
+		t.Error("tampered item was returned")
+	}
+	store.PutItem(context.Background(), itemKey, value[:4], time.Now().Add(time.Minute))
+	if _, ok := cache.Get(context.Background(), testCacheKey); ok {
+		t.Error("truncated item was returned")
+	}
+	if _, err := NewKVCache(store, CachePolicy{}, []byte("short")); err == nil {
+		t.Error("NewKVCache accepted an invalid AES key")
+	}
+}
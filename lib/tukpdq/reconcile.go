@@ -0,0 +1,74 @@
+package tukpdq
+
+import (
+	"strings"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+// Discrepancy is a difference in a demographic field between the patient returned by two sources. Values contains the value held by each source, keyed by source name (eg pdqv3, cgl)
+type Discrepancy struct {
+	Field    string            `json:"field"`
+	Values   map[string]string `json:"values"`
+	Severity string            `json:"severity"`
+}
+
+// ReconcileCGL compares the name, birth date, sex and postcode of the patient returned by an IHE source with the CGL BasicDetails and returns a Discrepancy for each field that differs
+func ReconcileCGL(pat TUKPatient, source string, cgl *CGLUserResponse) []Discrepancy {
+	if cgl == nil {
+		return nil
+	}
+	return ComparePatients(pat, source, newCGLPatient(cgl, pat.NHSOID), tukcnst.PDQ_SERVER_TYPE_CGL)
+}
+
+// ComparePatients compares the given name, family name, birth date, gender and postcode of patients a and b and returns a Discrepancy for each field that differs.
+//
+// Values are compared ignoring case and white space. Birth date and gender mismatches are high severity, family name and postcode mismatches are medium severity.
+// A given name mismatch is low severity if the first given names match, otherwise medium. A value held by only one source is low severity
+func ComparePatients(a TUKPatient, asrc string, b TUKPatient, bsrc string) []Discrepancy {
+	discrepancies := []Discrepancy{}
+	compare := func(field string, aval string, bval string, normalise func(string) string, severity func(string, string) string) {
+		na, nb := normalise(aval), normalise(bval)
+		if na == nb {
+			return
+		}
+		d := Discrepancy{
+			Field:    field,
+			Values:   map[string]string{asrc: aval, bsrc: bval},
+			Severity: tukcnst.DISCREPANCY_SEVERITY_LOW,
+		}
+		if na != "" && nb != "" {
+			d.Severity = severity(na, nb)
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	compare("familyname", a.FamilyName, b.FamilyName, normaliseName, severityOf(tukcnst.DISCREPANCY_SEVERITY_MEDIUM))
+	compare("givenname", a.GivenName, b.GivenName, normaliseName, givenNameSeverity)
+	compare("birthdate", a.BirthDate, b.BirthDate, getHL7Date, severityOf(tukcnst.DISCREPANCY_SEVERITY_HIGH))
+	compare("gender", a.Gender, b.Gender, normaliseGender, severityOf(tukcnst.DISCREPANCY_SEVERITY_HIGH))
+	compare("zip", a.Zip, b.Zip, normalisePostcode, severityOf(tukcnst.DISCREPANCY_SEVERITY_MEDIUM))
+	return discrepancies
+}
+func severityOf(severity string) func(string, string) string {
+	return func(string, string) string { return severity }
+}
+
+// givenNameSeverity returns low severity if the first given names match (eg a middle name held by only one source), otherwise medium
+func givenNameSeverity(a string, b string) string {
+	if strings.Fields(a)[0] == strings.Fields(b)[0] {
+		return tukcnst.DISCREPANCY_SEVERITY_LOW
+	}
+	return tukcnst.DISCREPANCY_SEVERITY_MEDIUM
+}
+func normaliseName(name string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(name), " "))
+}
+func normalisePostcode(postcode string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(postcode), ""))
+}
+func normaliseGender(gender string) string {
+	if strings.TrimSpace(gender) == "" {
+		return ""
+	}
+	return getHL7Gender(strings.TrimSpace(gender))
+}
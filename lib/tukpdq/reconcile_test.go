@@ -0,0 +1,86 @@
+package tukpdq
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+func TestComparePatients(t *testing.T) {
+	pat := TUKPatient{GivenName: "Nhs A", FamilyName: "Testpatient", BirthDate: "19620404", Gender: "female", Zip: "PR1 1PR"}
+	tests := []struct {
+		name         string
+		change       func(*TUKPatient)
+		wantField    string
+		wantSeverity string
+	}{
+		{"same patient", func(p *TUKPatient) {}, "", ""},
+		{"case and white space are ignored", func(p *TUKPatient) { p.GivenName, p.FamilyName, p.Zip = " nhs  a", "TESTPATIENT ", "pr11pr" }, "", ""},
+		{"fhir birth date", func(p *TUKPatient) { p.BirthDate = "1962-04-04" }, "", ""},
+		{"birth date with time", func(p *TUKPatient) { p.BirthDate = "1962-04-04T00:00:00Z" }, "", ""},
+		{"hl7 gender code", func(p *TUKPatient) { p.Gender = "F" }, "", ""},
+		{"family name mismatch", func(p *TUKPatient) { p.FamilyName = "Smith" }, "familyname", tukcnst.DISCREPANCY_SEVERITY_MEDIUM},
+		{"middle name held by one source", func(p *TUKPatient) { p.GivenName = "Nhs" }, "givenname", tukcnst.DISCREPANCY_SEVERITY_LOW},
+		{"first given name mismatch", func(p *TUKPatient) { p.GivenName = "Ann A" }, "givenname", tukcnst.DISCREPANCY_SEVERITY_MEDIUM},
+		{"birth date mismatch", func(p *TUKPatient) { p.BirthDate = "1962-04-05" }, "birthdate", tukcnst.DISCREPANCY_SEVERITY_HIGH},
+		{"gender mismatch", func(p *TUKPatient) { p.Gender = "male" }, "gender", tukcnst.DISCREPANCY_SEVERITY_HIGH},
+		{"postcode mismatch", func(p *TUKPatient) { p.Zip = "PR2 1PR" }, "zip", tukcnst.DISCREPANCY_SEVERITY_MEDIUM},
+		{"birth date held by one source", func(p *TUKPatient) { p.BirthDate = "" }, "birthdate", tukcnst.DISCREPANCY_SEVERITY_LOW},
+		{"postcode held by one source", func(p *TUKPatient) { p.Zip = "" }, "zip", tukcnst.DISCREPANCY_SEVERITY_LOW},
+	}
+	for _, tt := range tests {
+		other := pat
+		tt.change(&other)
+		got := ComparePatients(pat, tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, other, tukcnst.PDQ_SERVER_TYPE_CGL)
+		if tt.wantField == "" {
+			if len(got) != 0 {
+				t.Errorf("%s: discrepancies = %+v, want none", tt.name, got)
+			}
+			continue
+		}
+		if len(got) != 1 || got[0].Field != tt.wantField || got[0].Severity != tt.wantSeverity {
+			t.Errorf("%s: discrepancies = %+v, want one %s discrepancy of %s severity", tt.name, got, tt.wantField, tt.wantSeverity)
+			continue
+		}
+		if got[0].Values[tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3] == got[0].Values[tukcnst.PDQ_SERVER_TYPE_CGL] {
+			t.Errorf("%s: Values = %v, want the value held by each source", tt.name, got[0].Values)
+		}
+	}
+}
+
+func TestReconcileCGL(t *testing.T) {
+	pat := TUKPatient{NHSID: "9999999468", GivenName: "Nhs", FamilyName: "Testpatient", BirthDate: "19620404", Gender: "male", Zip: "PR1 1PR"}
+	tests := []struct {
+		name          string
+		cgl           string
+		wantBirthDate string
+		wantField     []string
+	}{
+		{"matching patient", `{"data":{"client":{"basicDetails":{"nhsNumber":"9999999468","name":{"family":"Testpatient","given":"Nhs"},"birthDate":"1962-04-04","sexAtBirth":"male","address":{"postCode":"PR1 1PR"}}}}}`, "19620404", nil},
+		{"birth date time is ignored", `{"data":{"client":{"basicDetails":{"nhsNumber":"9999999468","name":{"family":"Testpatient","given":"Nhs"},"birthDate":"1962-04-04T00:00:00","sexAtBirth":"Male","address":{"postCode":"pr1 1pr"}}}}}`, "19620404", nil},
+		{"birth date, sex and postcode mismatch", `{"data":{"client":{"basicDetails":{"nhsNumber":"9999999468","name":{"family":"Testpatient","given":"Nhs"},"birthDate":"1962-05-04","sexAtBirth":"female","address":{"postCode":"PR2 1PR"}}}}}`, "19620504", []string{"birthdate", "gender", "zip"}},
+	}
+	for _, tt := range tests {
+		cgl := CGLUserResponse{}
+		if err := json.Unmarshal([]byte(tt.cgl), &cgl); err != nil {
+			t.Fatal(err)
+		}
+		if cglpat := newCGLPatient(&cgl, testNHSOID); cglpat.BirthDate != tt.wantBirthDate {
+			t.Errorf("%s: cgl patient BirthDate = %q, want %q", tt.name, cglpat.BirthDate, tt.wantBirthDate)
+		}
+		got := ReconcileCGL(pat, tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, &cgl)
+		if len(got) != len(tt.wantField) {
+			t.Errorf("%s: discrepancies = %+v, want %v", tt.name, got, tt.wantField)
+			continue
+		}
+		for n, d := range got {
+			if d.Field != tt.wantField[n] {
+				t.Errorf("%s: discrepancy %v field = %s, want %s", tt.name, n, d.Field, tt.wantField[n])
+			}
+		}
+	}
+	if got := ReconcileCGL(pat, tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, nil); got != nil {
+		t.Errorf("ReconcileCGL with no cgl response = %+v, want nil", got)
+	}
+}
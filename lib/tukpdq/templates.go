@@ -0,0 +1,186 @@
+package tukpdq
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+// defaultTemplates are the built in request templates for each interaction, keyed by template name
+var defaultTemplates = map[string]string{
+	tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3: tukcnst.GO_Template_PDQ_V3_Request,
+	pdqv3ContinuationTemplate:         tukcnst.GO_Template_PDQ_V3_Continuation_Request,
+	tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3: tukcnst.GO_Template_PIX_V3_Request,
+	pixv3FeedTemplate:                 tukcnst.GO_Template_PIX_V3_Feed_Request,
+	tukcnst.PDQ_SERVER_TYPE_IHE_XCPD:  tukcnst.GO_Template_XCPD_Request,
+	tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2: tukcnst.GO_Template_PDQ_V2_Request,
+	tukcnst.PDQ_SERVER_TYPE_IHE_PIXV2: tukcnst.GO_Template_PIX_V2_Request,
+}
+
+var (
+	templates    = parseDefaultTemplates()
+	templates_mu sync.RWMutex
+)
+
+// TemplateNames returns the names of the request templates that can be overridden with SetTemplate, LoadTemplate or LoadTemplates.
+// pdqv3, pdqv3continuation, pixv3, pixv3feed and xcpd are SOAP templates and pdqv2 and pixv2 are HL7 v2 templates
+func TemplateNames() []string {
+	return []string{
+		tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3,
+		pdqv3ContinuationTemplate,
+		tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3,
+		pixv3FeedTemplate,
+		tukcnst.PDQ_SERVER_TYPE_IHE_XCPD,
+		tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2,
+		tukcnst.PDQ_SERVER_TYPE_IHE_PIXV2,
+	}
+}
+
+// SetTemplate replaces the named request template with text. The text may be the template body or contain a {{define "name"}} action for the template.
+// Query values must be escaped in the template with the xml function (SOAP templates) or the hl7v2 function (HL7 v2 templates), eg {{xml .Used_PID}}.
+// The template is only accepted if it parses and renders a sample query to well formed xml (SOAP templates) or to a message starting with a MSH segment (HL7 v2 templates)
+func SetTemplate(name string, text string) error {
+	if _, ok := defaultTemplates[name]; !ok {
+		return fmt.Errorf("%s is not a pdq request template", name)
+	}
+	tmplt, err := template.New(name).Funcs(templateFuncMap()).Parse(text)
+	if err != nil {
+		return err
+	}
+	if err = validateTemplate(name, tmplt); err != nil {
+		return fmt.Errorf("%s template is not valid - %s", name, err.Error())
+	}
+	templates_mu.Lock()
+	defer templates_mu.Unlock()
+	templates[name] = tmplt
+	return nil
+}
+
+// LoadTemplate replaces the named request template with the template in the file at path
+func LoadTemplate(name string, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return SetTemplate(name, string(b))
+}
+
+// LoadTemplates replaces each request template that has a file named {name}.tmpl (eg pdqv3.tmpl) in dir and returns the names of the templates loaded.
+// Templates without a file are unchanged. If any file is not a valid template, the templates loaded from the other files are kept and the errors are returned
+func LoadTemplates(dir string) ([]string, error) {
+	loaded := []string{}
+	var errs []string
+	for _, name := range TemplateNames() {
+		path := filepath.Join(dir, name+".tmpl")
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := LoadTemplate(name, path); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		loaded = append(loaded, name)
+	}
+	if len(errs) > 0 {
+		return loaded, errors.New(strings.Join(errs, " - "))
+	}
+	return loaded, nil
+}
+
+// parseDefaultTemplates parses the built in request templates. It panics if a built in template does not parse
+func parseDefaultTemplates() map[string]*template.Template {
+	tmplts := make(map[string]*template.Template)
+	for name, text := range defaultTemplates {
+		tmplts[name] = template.Must(template.New(name).Funcs(templateFuncMap()).Parse(text))
+	}
+	return tmplts
+}
+
+// validateTemplate executes the template against a sample query with every value used by the templates set and checks the result is well formed xml, or for HL7 v2 templates starts with a MSH segment
+func validateTemplate(name string, tmplt *template.Template) error {
+	sample := PDQQuery{
+		Server_Mode:        name,
+		NHS_ID:             "9999999468",
+		NHS_OID:            tukcnst.NHS_OID_DEFAULT,
+		MRN_ID:             "MRN1",
+		MRN_OID:            "1.2.3.4",
+		REG_ID:             "REG1",
+		REG_OID:            "1.2.3.5",
+		GivenName:          "Nhs",
+		FamilyName:         "Testpatient",
+		BirthDate:          "19620404",
+		Gender:             "male",
+		Zip:                "PR1 1PR",
+		Street:             "Preston Road",
+		Town:               "Fulwood",
+		City:               "Preston",
+		Country:            "GBR",
+		Phone:              "07777661324",
+		Email:              "nhs.testpatient@nhs.net",
+		Used_PID:           "9999999468",
+		Used_PID_OID:       tukcnst.NHS_OID_DEFAULT,
+		Initial_Quantity:   10,
+		Message_ID:         "00000000-0000-0000-0000-000000000001",
+		Query_ID:           "00000000-0000-0000-0000-000000000002",
+		Query_ID_Root:      "1.2.3.6",
+		Target_Systems:     []string{"1.2.3.5"},
+		Feed_Action:        tukcnst.PIX_FEED_ACTION_ADD,
+		Home_Community_OID: "1.2.3.7",
+		Community_OID:      "1.2.3.8",
+	}
+	sample.setDevices()
+	var b bytes.Buffer
+	if err := tmplt.Execute(&b, &sample); err != nil {
+		return err
+	}
+	switch name {
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2, tukcnst.PDQ_SERVER_TYPE_IHE_PIXV2:
+		if !strings.HasPrefix(b.String(), "MSH|") {
+			return errors.New("hl7 v2 message does not start with a MSH segment")
+		}
+		return nil
+	}
+	dec := xml.NewDecoder(&b)
+	root := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := tok.(xml.StartElement); ok {
+			root = true
+		}
+	}
+	if !root {
+		return errors.New("template renders no xml elements")
+	}
+	return nil
+}
+
+// setTemplateRequest sets the Request to the result of executing the named request template against the pdq
+func (i *PDQQuery) setTemplateRequest(name string) error {
+	templates_mu.RLock()
+	tmplt, ok := templates[name]
+	templates_mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%s is not a pdq request template", name)
+	}
+	var b bytes.Buffer
+	if err := tmplt.Execute(&b, i); err != nil {
+		return err
+	}
+	i.Request = b.Bytes()
+	return nil
+}
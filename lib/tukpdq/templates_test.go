@@ -0,0 +1,84 @@
+package tukpdq
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+// restoreTemplates restores the built in request templates when the test ends
+func restoreTemplates(t *testing.T) {
+	t.Cleanup(func() {
+		templates_mu.Lock()
+		templates = parseDefaultTemplates()
+		templates_mu.Unlock()
+	})
+}
+
+func TestLoadTemplateRejectsInvalidTemplates(t *testing.T) {
+	restoreTemplates(t)
+	tests := []struct {
+		name     string
+		template string
+		text     string
+	}{
+		{"mismatched xml tags", tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><S:Body>{{xml .Used_PID}}</S:Header></S:Envelope>`},
+		{"unclosed xml element", tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3, `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><S:Body>{{xml .Used_PID}}`},
+		{"no xml elements", tukcnst.PDQ_SERVER_TYPE_IHE_XCPD, `{{xml .Used_PID}}`},
+		{"unescaped value breaks the xml", pixv3FeedTemplate, `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><name>{{.FamilyName}} & {{.GivenName}}</name></S:Envelope>`},
+		{"template does not parse", pdqv3ContinuationTemplate, `<S:Envelope>{{xml .Query_ID</S:Envelope>`},
+		{"unknown query field", tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, `<S:Envelope>{{xml .No_Such_Field}}</S:Envelope>`},
+		{"hl7 v2 message without a msh segment", tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2, "QPD|IHE PDQ Query|1|@PID.3.1^{{hl7v2 .Used_PID}}\r"},
+		{"hl7 v2 message does not parse", tukcnst.PDQ_SERVER_TYPE_IHE_PIXV2, "MSH|^~\\&|{{hl7v2 .Used_PID}\r"},
+	}
+	dir := t.TempDir()
+	for n, tt := range tests {
+		templates_mu.RLock()
+		want := templates[tt.template]
+		templates_mu.RUnlock()
+		path := filepath.Join(dir, tt.template+string(rune('a'+n))+".tmpl")
+		if err := os.WriteFile(path, []byte(tt.text), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadTemplate(tt.template, path); err == nil {
+			t.Errorf("%s: LoadTemplate accepted the invalid %s template", tt.name, tt.template)
+		}
+		templates_mu.RLock()
+		got := templates[tt.template]
+		templates_mu.RUnlock()
+		if got != want {
+			t.Errorf("%s: the registered %s template was replaced", tt.name, tt.template)
+		}
+	}
+	if err := SetTemplate("pdqv4", `<a/>`); err == nil {
+		t.Error("SetTemplate accepted an unknown template name")
+	}
+	if err := LoadTemplate(tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, filepath.Join(dir, "missing.tmpl")); err == nil {
+		t.Error("LoadTemplate accepted a missing file")
+	}
+}
+
+func TestLoadTemplatesKeepsValidTemplates(t *testing.T) {
+	restoreTemplates(t)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3+".tmpl"), []byte(`<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><id>{{xml .Used_PID}}</id></S:Envelope>`), 0600)
+	os.WriteFile(filepath.Join(dir, tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3+".tmpl"), []byte(`<S:Envelope><id>{{xml .Used_PID}}</S:Envelope>`), 0600)
+	templates_mu.RLock()
+	pixv3 := templates[tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3]
+	templates_mu.RUnlock()
+	loaded, err := LoadTemplates(dir)
+	if err == nil || len(loaded) != 1 || loaded[0] != tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3 {
+		t.Fatalf("LoadTemplates = %v %v, want pdqv3 loaded and a pixv3 error", loaded, err)
+	}
+	pdqv3 := PDQQuery{Used_PID: "9999999468"}
+	if err = pdqv3.setTemplateRequest(tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3); err != nil || string(pdqv3.Request) != `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><id>9999999468</id></S:Envelope>` {
+		t.Errorf("pdqv3 request = %s %v, want the loaded template", pdqv3.Request, err)
+	}
+	templates_mu.RLock()
+	defer templates_mu.RUnlock()
+	if templates[tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3] != pixv3 {
+		t.Error("the invalid pixv3 template replaced the built in template")
+	}
+}
@@ -0,0 +1,1816 @@
+// tukpdq provides a golang implementtion of, IHE PIXm,IHE PIXv3, IHE PDQv3 and IHE PIX and PDQ (HL7 v2 over MLLP) Client Consumers, an IHE XCPD Initiating Gateway, and IHE PIXv3 and PIXm Patient Identity Sources
+//
+// There is currently no authentication implemented. The func (i *PDQQuery) newRequest() error is used to handle the http request/response and should be amended according to your authentication requirements
+//
+// Struct PDQQuery implements the tukpdq.PDQ() interface
+//
+//	type PDQQuery struct {
+//		Count        int          `json:"count"`
+//		PID          string       `json:"pid"`
+//		PIDOID       string       `json:"pidoid"`
+//		PIX_URL      string       `json:"pixurl"`
+//		NHS_OID      string       `json:"nhsoid"`
+//		Region_OID   string       `json:"regionoid"`
+//		Timeout      int64        `json:"timeout"`
+//		StatusCode   int          `json:"statuscode"`
+//		Response     []byte       `json:"response"`
+//		PIXmResponse PIXmResponse `json:"pixmresponse"`
+//		Patients     []PIXPatient `json:"patients"`
+//	}
+//
+//	 PID is the MRN or NHS ID or Regional/xds ID and is required
+//	 Region_OID is the Regional/XDS OID and is required
+//	 PIX_URL is the PIXm WS end point and is required.
+//	 PID_OID is required if the PID is not an NHS ID. If pid length = 10 and no PID_OID is provided, the pid is assumed to be a NHS ID and the PID_OID is set to the NHS offical NHS ID OID (2.16.840.1.113883.2.1.4.1)
+//	 Timeout sets the http context timeout in seconds and is optional. Default is 5 secs
+//	 NHS_OID is optional. Default is 2.16.840.1.113883.2.1.4.1
+//	 Count will be set from the pixm response to the number of patients found matching the query
+//	 Response will contain the PIXm response in []byte format
+//	 PIXmResponse will contain the initialised PIXmResponse struc from the Response []byte
+//	 StatusCode will be set from the PIXm Server http response header statuscode
+//	 []Patients is any array of PIXPatient structs containing all matched patients. Hopefully just 1 !!
+//
+//	Example usage:
+//		pdq := tukpdq.PIXmQuery{
+//			PID:        "9999999468",
+//			Region_OID: "2.16.840.1.113883.2.1.3.31.2.1.1",
+//			PIX_URL:    "http://spirit-test-01.tianispirit.co.uk:8081/SpiritPIXFhir/r4/Patient",
+//		}
+//		if err = tukpdq.PDQ(&pdq); err == nil {
+//			log.Printf("Patient %s %s is registered", pdq.Patients[0].GivenName, pdq.Patients[0].FamilyName)
+//		} else {
+//			log.Println(err.Error())
+//		}
+//
+//	Running the above example produces the following Log output:
+//
+//	2022/09/12 14:02:55.510679 tukpdq.go:188: HTTP GET Request Headers
+//
+//	2022/09/12 14:02:55.510834 tukpdq.go:190: {
+//	  "Accept": [
+//	    "*/*"
+//	  ],
+//	  "Connection": [
+//	    "keep-alive"
+//	  ],
+//	  "Content-Type": [
+//	    "application/json"
+//	  ]
+//	}
+//
+// 2022/09/12 14:02:55.510860 tukpdq.go:191: HTTP Request
+// URL = http://spirit-test-01.tianispirit.co.uk:8081/SpiritPIXFhir/r4/Patient?identifier=2.16.840.1.113883.2.1.4.1%7C9999999468&_format=json&_pretty=true
+// 2022/09/12 14:02:55.851605 tukpdq.go:194: HTML Response - Status Code = 200
+//
+//	{
+//	  "resourceType": "Bundle",
+//	  "id": "53c44d32-fb2c-4dfb-b819-db2150e6fa87",
+//	  "type": "searchset",
+//	  "total": 1,
+//	  "link": [ {
+//	    "relation": "self",
+//	    "url": "http://spirit-test-01.tianispirit.co.uk:8081/SpiritPIXFhir/r4/Patient?_format=json&_pretty=true&identifier=2.16.840.1.113883.2.1.4.1%7C9999999468"
+//	  } ],
+//	  "entry": [ {
+//	    "fullUrl": "http://spirit-test-01.tianispirit.co.uk:8081/SpiritPIXFhir/r4/Patient/VFNVSy4xNjYxOTc2MjMwMjYxMSYyLjE2Ljg0MC4xLjExMzg4My4yLjEuMy4zMS4yLjEuMS4xLjMuMS4x",
+//	    "resource": {
+//	      "resourceType": "Patient",
+//	      "id": "VFNVSy4xNjYxOTc2MjMwMjYxMSYyLjE2Ljg0MC4xLjExMzg4My4yLjEuMy4zMS4yLjEuMS4xLjMuMS4x",
+//	      "extension": [ {
+//	        "url": "http://hl7.org/fhir/StructureDefinition/patient-citizenship",
+//	        "valueCodeableConcept": {
+//	          "coding": [ {
+//	            "code": "GBR"
+//	          } ]
+//	        }
+//	      }, {
+//	        "url": "http://hl7.org/fhir/StructureDefinition/patient-nationality",
+//	        "valueCodeableConcept": {
+//	          "coding": [ {
+//	            "code": "GBR"
+//	          } ]
+//	        }
+//	      } ],
+//	      "identifier": [ {
+//	        "system": "urn:oid:2.16.840.1.113883.2.1.4.1",
+//	        "value": "9999999468"
+//	      }, {
+//	        "use": "usual",
+//	        "system": "urn:oid:2.16.840.1.113883.2.1.3.31.2.1.1.1.3.1.1",
+//	        "value": "TSUK.16619762302611"
+//	      }, {
+//	        "system": "urn:oid:2.16.840.1.113883.2.1.3.31.2.1.1",
+//	        "value": "REG.1MWU5C92M2"
+//	      } ],
+//	      "active": true,
+//	      "name": [ {
+//	        "use": "official",
+//	        "family": "Testpatient",
+//	        "given": [ "Nhs" ]
+//	      } ],
+//	      "telecom": [ {
+//	        "system": "phone",
+//	        "value": "07777661324",
+//	        "use": "work"
+//	      }, {
+//	        "system": "email",
+//	        "value": "nhs.testpatient@nhs.net",
+//	        "use": "work"
+//	      } ],
+//	      "gender": "male",
+//	      "birthDate": "1962-04-04",
+//	      "address": [ {
+//	        "line": [ "Preston Road" ],
+//	        "city": "Preston",
+//	        "state": "Lancashire",
+//	        "postalCode": "PR1 1PR",
+//	        "country": "GBR"
+//	      } ],
+//	      "maritalStatus": {
+//	        "coding": [ {
+//	          "code": "D"
+//	        } ]
+//	      },
+//	      "multipleBirthBoolean": false
+//	    }
+//	  } ]
+//	}
+//
+// 2022/09/12 14:02:55.852334 tukpdq.go:102: 1 Patient Entries in Response
+// 2022/09/12 14:02:55.852392 tukpdq.go:122: Set NHS ID 9999999468 2.16.840.1.113883.2.1.4.1
+// 2022/09/12 14:02:55.852427 tukpdq.go:117: Set PID TSUK.16619762302611 2.16.840.1.113883.2.1.3.31.2.1.1.1.3.1.1
+// 2022/09/12 14:02:55.852455 tukpdq.go:112: Set Reg ID REG.1MWU5C92M2 2.16.840.1.113883.2.1.3.31.2.1.1
+// 2022/09/12 14:02:55.852546 tukpdq.go:149: Added Patient 9999999468 to response
+// 2022/09/12 14:02:55.852569 main.go:84: Patient Nhs Testpatient is registered
+package tukpdq
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ipthomas/tukcnst"
+	"github.com/ipthomas/tukhttp"
+	"github.com/ipthomas/tukutil"
+)
+
+type PDQQuery struct {
+	Server_Mode            string                  `json:",omitempty"`
+	Server_URL             string                  `json:",omitempty"`
+	CGL_X_Api_Key          string                  `json:"-"`
+	NHS_ID                 string                  `json:",omitempty"`
+	NHS_OID                string                  `json:",omitempty"`
+	MRN_ID                 string                  `json:",omitempty"`
+	MRN_OID                string                  `json:",omitempty"`
+	REG_ID                 string                  `json:",omitempty"`
+	REG_OID                string                  `json:",omitempty"`
+	GivenName              string                  `json:"givenname"`
+	FamilyName             string                  `json:"familyname"`
+	BirthDate              string                  `json:"birthdate"`
+	Gender                 string                  `json:"gender"`
+	Zip                    string                  `json:"zip"`
+	Street                 string                  `json:"street"`
+	Town                   string                  `json:"town"`
+	City                   string                  `json:"city"`
+	Country                string                  `json:"country"`
+	Phone                  string                  `json:"phone"`
+	Email                  string                  `json:"email"`
+	Timeout                int64                   `json:",omitempty"`
+	Cache                  bool                    `json:",omitempty"`
+	Cached                 bool                    `json:",omitempty"`
+	Cache_Age              int64                   `json:",omitempty"`
+	Cache_Stale            bool                    `json:",omitempty"`
+	Used_PID               string                  `json:",omitempty"`
+	Used_PID_OID           string                  `json:",omitempty"`
+	Initial_Quantity       int                     `json:",omitempty"`
+	Continuation_Token     string                  `json:",omitempty"`
+	Cancel                 bool                    `json:",omitempty"`
+	Remaining              int                     `json:",omitempty"`
+	Message_ID             string                  `json:",omitempty"`
+	Query_ID               string                  `json:",omitempty"`
+	Query_ID_Root          string                  `json:",omitempty"`
+	Target_Systems         []string                `json:",omitempty"`
+	Feed_Action            string                  `json:",omitempty"`
+	Ack_Code               string                  `json:",omitempty"`
+	Home_Community_OID     string                  `json:",omitempty"`
+	Community_OID          string                  `json:",omitempty"`
+	XCPD_Gateways          []XCPDGateway           `json:",omitempty"`
+	Sender                 HL7v3Device             `json:",omitempty"`
+	Receiver               HL7v3Device             `json:",omitempty"`
+	Request                []byte                  `json:",omitempty"`
+	Response               []byte                  `json:",omitempty"`
+	StatusCode             int                     `json:",omitempty"`
+	Count                  int                     `json:",omitempty"`
+	PDQv3Response          *PDQv3Response          `json:",omitempty"`
+	PIXv3Response          *PIXv3Response          `json:",omitempty"`
+	PIXmResponse           *PIXmResponse           `json:",omitempty"`
+	PIXmParametersResponse *PIXmParametersResponse `json:",omitempty"`
+	Patients               *[]TUKPatient           `json:",omitempty"`
+	CGLUserResponse        *CGLUserResponse        `json:",omitempty"`
+	HL7v3AckResponse       *HL7v3AckResponse       `json:",omitempty"`
+	HL7v2Response          *HL7v2Response          `json:",omitempty"`
+	ctx                    context.Context
+}
+type CGLUserResponse struct {
+	Data struct {
+		Client struct {
+			BasicDetails struct {
+				Address struct {
+					AddressLine1 string `json:"addressLine1,omitempty"`
+					AddressLine2 string `json:"addressLine2,omitempty"`
+					AddressLine3 string `json:"addressLine3,omitempty"`
+					AddressLine4 string `json:"addressLine4,omitempty"`
+					AddressLine5 string `json:"addressLine5,omitempty"`
+					PostCode     string `json:"postCode,omitempty"`
+				} `json:"address,omitempty"`
+				BirthDate                    string `json:"birthDate,omitempty"`
+				Disability                   string `json:"disability,omitempty"`
+				LastEngagementByCGLDate      string `json:"lastEngagementByCGLDate,omitempty"`
+				LastFaceToFaceEngagementDate string `json:"lastFaceToFaceEngagementDate,omitempty"`
+				LocalIdentifier              int    `json:"localIdentifier,omitempty"`
+				Name                         struct {
+					Family string `json:"family,omitempty"`
+					Given  string `json:"given,omitempty"`
+				} `json:"name,omitempty"`
+				NextCGLAppointmentDate string `json:"nextCGLAppointmentDate,omitempty"`
+				NhsNumber              string `json:"nhsNumber,omitempty"`
+				SexAtBirth             string `json:"sexAtBirth,omitempty"`
+			} `json:"basicDetails,omitempty"`
+			BbvInformation struct {
+				BbvTested        string `json:"bbvTested,omitempty"`
+				HepCLastTestDate string `json:"hepCLastTestDate,omitempty"`
+				HepCResult       string `json:"hepCResult,omitempty"`
+				HivPositive      string `json:"hivPositive,omitempty"`
+			} `json:"bbvInformation,omitempty"`
+			DrugTestResults struct {
+				DrugTestDate          string `json:"drugTestDate,omitempty"`
+				DrugTestSample        string `json:"drugTestSample,omitempty"`
+				DrugTestStatus        string `json:"drugTestStatus,omitempty"`
+				InstantOrConfirmation string `json:"instantOrConfirmation,omitempty"`
+				Results               struct {
+					Amphetamine     string `json:"amphetamine,omitempty"`
+					Benzodiazepine  string `json:"benzodiazepine,omitempty"`
+					Buprenorphine   string `json:"buprenorphine,omitempty"`
+					Cannabis        string `json:"cannabis,omitempty"`
+					Cocaine         string `json:"cocaine,omitempty"`
+					Eddp            string `json:"eddp,omitempty"`
+					Fentanyl        string `json:"fentanyl,omitempty"`
+					Ketamine        string `json:"ketamine,omitempty"`
+					Methadone       string `json:"methadone,omitempty"`
+					Methamphetamine string `json:"methamphetamine,omitempty"`
+					Morphine        string `json:"morphine,omitempty"`
+					Opiates         string `json:"opiates,omitempty"`
+					SixMam          string `json:"sixMam,omitempty"`
+					Tramadol        string `json:"tramadol,omitempty"`
+				} `json:"results,omitempty"`
+			} `json:"drugTestResults,omitempty"`
+			PrescribingInformation []string `json:"prescribingInformation,omitempty"`
+			RiskInformation        struct {
+				LastSelfReportedDate string `json:"lastSelfReportedDate,omitempty"`
+				MentalHealthDomain   struct {
+					AttemptedSuicide                            string `json:"attemptedSuicide,omitempty"`
+					CurrentOrPreviousSelfHarm                   string `json:"currentOrPreviousSelfHarm,omitempty"`
+					DiagnosedMentalHealthCondition              string `json:"diagnosedMentalHealthCondition,omitempty"`
+					FrequentLifeThreateningSelfHarm             string `json:"frequentLifeThreateningSelfHarm,omitempty"`
+					Hallucinations                              string `json:"hallucinations,omitempty"`
+					HospitalAdmissionsForMentalHealth           string `json:"hospitalAdmissionsForMentalHealth,omitempty"`
+					NoIdentifiedRisk                            string `json:"noIdentifiedRisk,omitempty"`
+					NotEngagingWithSupport                      string `json:"notEngagingWithSupport,omitempty"`
+					NotTakingPrescribedMedicationAsInstructed   string `json:"notTakingPrescribedMedicationAsInstructed,omitempty"`
+					PsychiatricOrPreviousCrisisTeamIntervention string `json:"psychiatricOrPreviousCrisisTeamIntervention,omitempty"`
+					Psychosis                                   string `json:"psychosis,omitempty"`
+					SelfReportedMentalHealthConcerns            string `json:"selfReportedMentalHealthConcerns,omitempty"`
+					ThoughtsOfSuicideOrSelfHarm                 string `json:"thoughtsOfSuicideOrSelfHarm,omitempty"`
+				} `json:"mentalHealthDomain,omitempty"`
+				RiskOfHarmToSelfDomain struct {
+					AssessedAsNotHavingMentalCapacity  string `json:"assessedAsNotHavingMentalCapacity,omitempty"`
+					BeliefTheyAreWorthless             string `json:"beliefTheyAreWorthless,omitempty"`
+					Hoarding                           string `json:"hoarding,omitempty"`
+					LearningDisability                 string `json:"learningDisability,omitempty"`
+					MeetsSafeguardingAdultsThreshold   string `json:"meetsSafeguardingAdultsThreshold,omitempty"`
+					NoIdentifiedRisk                   string `json:"noIdentifiedRisk,omitempty"`
+					OngoingConcernsRelatingToOwnSafety string `json:"ongoingConcernsRelatingToOwnSafety,omitempty"`
+					ProblemsMaintainingPersonalHygiene string `json:"problemsMaintainingPersonalHygiene,omitempty"`
+					ProblemsMeetingNutritionalNeeds    string `json:"problemsMeetingNutritionalNeeds,omitempty"`
+					RequiresIndependentAdvocacy        string `json:"requiresIndependentAdvocacy,omitempty"`
+					SelfNeglect                        string `json:"selfNeglect,omitempty"`
+				} `json:"riskOfHarmToSelfDomain,omitempty"`
+				SocialDomain struct {
+					FinancialProblems         string `json:"financialProblems,omitempty"`
+					HomelessRoughSleepingNFA  string `json:"homelessRoughSleepingNFA,omitempty"`
+					HousingAtRisk             string `json:"housingAtRisk,omitempty"`
+					NoIdentifiedRisk          string `json:"noIdentifiedRisk,omitempty"`
+					SociallyIsolatedNoSupport string `json:"sociallyIsolatedNoSupport,omitempty"`
+				} `json:"socialDomain,omitempty"`
+				SubstanceMisuseDomain struct {
+					ConfusionOrDisorientation string `json:"ConfusionOrDisorientation,omitempty"`
+					AdmissionToAandE          string `json:"admissionToAandE,omitempty"`
+					BlackoutOrSeizures        string `json:"blackoutOrSeizures,omitempty"`
+					ConcurrentUse             string `json:"concurrentUse,omitempty"`
+					HigherRiskDrinking        string `json:"higherRiskDrinking,omitempty"`
+					InjectedByOthers          string `json:"injectedByOthers,omitempty"`
+					Injecting                 string `json:"injecting,omitempty"`
+					InjectingInNeckOrGroin    string `json:"injectingInNeckOrGroin,omitempty"`
+					NoIdentifiedRisk          string `json:"noIdentifiedRisk,omitempty"`
+					PolyDrugUse               string `json:"polyDrugUse,omitempty"`
+					PreviousOverDose          string `json:"previousOverDose,omitempty"`
+					RecentPrisonRelease       string `json:"recentPrisonRelease,omitempty"`
+					ReducedTolerance          string `json:"reducedTolerance,omitempty"`
+					SharingWorks              string `json:"sharingWorks,omitempty"`
+					Speedballing              string `json:"speedballing,omitempty"`
+					UsingOnTop                string `json:"usingOnTop,omitempty"`
+				} `json:"substanceMisuseDomain,omitempty"`
+			} `json:"riskInformation,omitempty"`
+			SafeguardingInformation struct {
+				LastReviewDate     string `json:"lastReviewDate,omitempty"`
+				RiskHarmFromOthers string `json:"riskHarmFromOthers,omitempty"`
+				RiskToAdults       string `json:"riskToAdults,omitempty"`
+				RiskToChildrenOrYP string `json:"riskToChildrenOrYP,omitempty"`
+				RiskToSelf         string `json:"riskToSelf,omitempty"`
+			} `json:"safeguardingInformation,omitempty"`
+		} `json:"client,omitempty"`
+		KeyWorker struct {
+			LocalIdentifier int `json:"localIdentifier,omitempty"`
+			Name            struct {
+				Family string `json:"family,omitempty"`
+				Given  string `json:"given,omitempty"`
+			} `json:"name"`
+			Telecom string `json:"telecom,omitempty"`
+		} `json:"keyWorker,omitempty"`
+	} `json:"data,omitempty"`
+}
+type PDQv3Response struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Text    string   `xml:",chardata"`
+	S       string   `xml:"S,attr"`
+	Env     string   `xml:"env,attr"`
+	Header  struct {
+		Text   string `xml:",chardata"`
+		Action struct {
+			Text  string `xml:",chardata"`
+			Xmlns string `xml:"xmlns,attr"`
+		} `xml:"Action"`
+		MessageID struct {
+			Text  string `xml:",chardata"`
+			Xmlns string `xml:"xmlns,attr"`
+		} `xml:"MessageID"`
+		RelatesTo struct {
+			Text  string `xml:",chardata"`
+			Xmlns string `xml:"xmlns,attr"`
+		} `xml:"RelatesTo"`
+		To struct {
+			Text  string `xml:",chardata"`
+			Xmlns string `xml:"xmlns,attr"`
+		} `xml:"To"`
+	} `xml:"Header"`
+	Body struct {
+		Text             string `xml:",chardata"`
+		PRPAIN201306UV02 struct {
+			Text       string `xml:",chardata"`
+			Xmlns      string `xml:"xmlns,attr"`
+			ITSVersion string `xml:"ITSVersion,attr"`
+			ID         struct {
+				Text      string `xml:",chardata"`
+				Extension string `xml:"extension,attr"`
+				Root      string `xml:"root,attr"`
+			} `xml:"id"`
+			CreationTime struct {
+				Text  string `xml:",chardata"`
+				Value string `xml:"value,attr"`
+			} `xml:"creationTime"`
+			VersionCode struct {
+				Text string `xml:",chardata"`
+				Code string `xml:"code,attr"`
+			} `xml:"versionCode"`
+			InteractionId struct {
+				Text      string `xml:",chardata"`
+				Extension string `xml:"extension,attr"`
+				Root      string `xml:"root,attr"`
+			} `xml:"interactionId"`
+			ProcessingCode struct {
+				Text string `xml:",chardata"`
+				Code string `xml:"code,attr"`
+			} `xml:"processingCode"`
+			ProcessingModeCode struct {
+				Text string `xml:",chardata"`
+				Code string `xml:"code,attr"`
+			} `xml:"processingModeCode"`
+			AcceptAckCode struct {
+				Text string `xml:",chardata"`
+				Code string `xml:"code,attr"`
+			} `xml:"acceptAckCode"`
+			Receiver struct {
+				Text     string `xml:",chardata"`
+				TypeCode string `xml:"typeCode,attr"`
+				Device   struct {
+					Text           string `xml:",chardata"`
+					ClassCode      string `xml:"classCode,attr"`
+					DeterminerCode string `xml:"determinerCode,attr"`
+					ID             struct {
+						Text                   string `xml:",chardata"`
+						AssigningAuthorityName string `xml:"assigningAuthorityName,attr"`
+						Root                   string `xml:"root,attr"`
+					} `xml:"id"`
+					AsAgent struct {
+						Text                    string `xml:",chardata"`
+						ClassCode               string `xml:"classCode,attr"`
+						RepresentedOrganization struct {
+							Text           string `xml:",chardata"`
+							ClassCode      string `xml:"classCode,attr"`
+							DeterminerCode string `xml:"determinerCode,attr"`
+							ID             struct {
+								Text                   string `xml:",chardata"`
+								AssigningAuthorityName string `xml:"assigningAuthorityName,attr"`
+								Root                   string `xml:"root,attr"`
+							} `xml:"id"`
+						} `xml:"representedOrganization"`
+					} `xml:"asAgent"`
+				} `xml:"device"`
+			} `xml:"receiver"`
+			Sender struct {
+				Text     string `xml:",chardata"`
+				TypeCode string `xml:"typeCode,attr"`
+				Device   struct {
+					Text           string `xml:",chardata"`
+					ClassCode      string `xml:"classCode,attr"`
+					DeterminerCode string `xml:"determinerCode,attr"`
+					ID             struct {
+						Text string `xml:",chardata"`
+						Root string `xml:"root,attr"`
+					} `xml:"id"`
+					AsAgent struct {
+						Text                    string `xml:",chardata"`
+						ClassCode               string `xml:"classCode,attr"`
+						RepresentedOrganization struct {
+							Text           string `xml:",chardata"`
+							ClassCode      string `xml:"classCode,attr"`
+							DeterminerCode string `xml:"determinerCode,attr"`
+							ID             struct {
+								Text string `xml:",chardata"`
+								Root string `xml:"root,attr"`
+							} `xml:"id"`
+						} `xml:"representedOrganization"`
+					} `xml:"asAgent"`
+				} `xml:"device"`
+			} `xml:"sender"`
+			Acknowledgement struct {
+				Text     string `xml:",chardata"`
+				TypeCode struct {
+					Text string `xml:",chardata"`
+					Code string `xml:"code,attr"`
+				} `xml:"typeCode"`
+				TargetMessage struct {
+					Text string `xml:",chardata"`
+					ID   struct {
+						Text      string `xml:",chardata"`
+						Extension string `xml:"extension,attr"`
+						Root      string `xml:"root,attr"`
+					} `xml:"id"`
+				} `xml:"targetMessage"`
+				AcknowledgementDetail []HL7v3AcknowledgementDetail `xml:"acknowledgementDetail"`
+			} `xml:"acknowledgement"`
+			ControlActProcess struct {
+				Text      string `xml:",chardata"`
+				ClassCode string `xml:"classCode,attr"`
+				MoodCode  string `xml:"moodCode,attr"`
+				Code      struct {
+					Text       string `xml:",chardata"`
+					Code       string `xml:"code,attr"`
+					CodeSystem string `xml:"codeSystem,attr"`
+				} `xml:"code"`
+				Subject []struct {
+					Text                 string `xml:",chardata"`
+					ContextConductionInd string `xml:"contextConductionInd,attr"`
+					TypeCode             string `xml:"typeCode,attr"`
+					RegistrationEvent    struct {
+						Text      string `xml:",chardata"`
+						ClassCode string `xml:"classCode,attr"`
+						MoodCode  string `xml:"moodCode,attr"`
+						ID        struct {
+							Text       string `xml:",chardata"`
+							NullFlavor string `xml:"nullFlavor,attr"`
+						} `xml:"id"`
+						StatusCode struct {
+							Text string `xml:",chardata"`
+							Code string `xml:"code,attr"`
+						} `xml:"statusCode"`
+						Subject1 struct {
+							Text     string `xml:",chardata"`
+							TypeCode string `xml:"typeCode,attr"`
+							Patient  struct {
+								Text      string `xml:",chardata"`
+								ClassCode string `xml:"classCode,attr"`
+								ID        []struct {
+									Text                   string `xml:",chardata"`
+									AssigningAuthorityName string `xml:"assigningAuthorityName,attr"`
+									Extension              string `xml:"extension,attr"`
+									Root                   string `xml:"root,attr"`
+								} `xml:"id"`
+								StatusCode struct {
+									Text string `xml:",chardata"`
+									Code string `xml:"code,attr"`
+								} `xml:"statusCode"`
+								EffectiveTime struct {
+									Text  string `xml:",chardata"`
+									Value string `xml:"value,attr"`
+								} `xml:"effectiveTime"`
+								PatientPerson struct {
+									Text           string `xml:",chardata"`
+									ClassCode      string `xml:"classCode,attr"`
+									DeterminerCode string `xml:"determinerCode,attr"`
+									Name           struct {
+										Text   string   `xml:",chardata"`
+										Use    string   `xml:"use,attr"`
+										Given  []string `xml:"given"`
+										Family string   `xml:"family"`
+									} `xml:"name"`
+									Telecom []struct {
+										Text  string `xml:",chardata"`
+										Use   string `xml:"use,attr"`
+										Value string `xml:"value,attr"`
+									} `xml:"telecom"`
+									AdministrativeGenderCode struct {
+										Text           string `xml:",chardata"`
+										Code           string `xml:"code,attr"`
+										CodeSystem     string `xml:"codeSystem,attr"`
+										CodeSystemName string `xml:"codeSystemName,attr"`
+									} `xml:"administrativeGenderCode"`
+									BirthTime struct {
+										Text  string `xml:",chardata"`
+										Value string `xml:"value,attr"`
+									} `xml:"birthTime"`
+									DeceasedInd struct {
+										Text  string `xml:",chardata"`
+										Value string `xml:"value,attr"`
+									} `xml:"deceasedInd"`
+									MultipleBirthInd struct {
+										Text  string `xml:",chardata"`
+										Value string `xml:"value,attr"`
+									} `xml:"multipleBirthInd"`
+									Addr struct {
+										Text              string   `xml:",chardata"`
+										StreetAddressLine []string `xml:"streetAddressLine"`
+										City              string   `xml:"city"`
+										State             string   `xml:"state"`
+										PostalCode        string   `xml:"postalCode"`
+										Country           string   `xml:"country"`
+									} `xml:"addr"`
+									MaritalStatusCode struct {
+										Text           string `xml:",chardata"`
+										Code           string `xml:"code,attr"`
+										CodeSystem     string `xml:"codeSystem,attr"`
+										CodeSystemName string `xml:"codeSystemName,attr"`
+									} `xml:"maritalStatusCode"`
+									AsCitizen struct {
+										Text            string `xml:",chardata"`
+										ClassCode       string `xml:"classCode,attr"`
+										PoliticalNation struct {
+											Text           string `xml:",chardata"`
+											ClassCode      string `xml:"classCode,attr"`
+											DeterminerCode string `xml:"determinerCode,attr"`
+											Code           struct {
+												Text string `xml:",chardata"`
+												Code string `xml:"code,attr"`
+											} `xml:"code"`
+										} `xml:"politicalNation"`
+									} `xml:"asCitizen"`
+									AsMember struct {
+										Text      string `xml:",chardata"`
+										ClassCode string `xml:"classCode,attr"`
+										Group     struct {
+											Text           string `xml:",chardata"`
+											ClassCode      string `xml:"classCode,attr"`
+											DeterminerCode string `xml:"determinerCode,attr"`
+											Code           struct {
+												Text           string `xml:",chardata"`
+												Code           string `xml:"code,attr"`
+												CodeSystem     string `xml:"codeSystem,attr"`
+												CodeSystemName string `xml:"codeSystemName,attr"`
+											} `xml:"code"`
+										} `xml:"group"`
+									} `xml:"asMember"`
+									BirthPlace struct {
+										Text string `xml:",chardata"`
+										Addr struct {
+											Text string `xml:",chardata"`
+											City string `xml:"city"`
+										} `xml:"addr"`
+									} `xml:"birthPlace"`
+								} `xml:"patientPerson"`
+								ProviderOrganization struct {
+									Text           string `xml:",chardata"`
+									ClassCode      string `xml:"classCode,attr"`
+									DeterminerCode string `xml:"determinerCode,attr"`
+									ID             struct {
+										Text       string `xml:",chardata"`
+										NullFlavor string `xml:"nullFlavor,attr"`
+									} `xml:"id"`
+									ContactParty struct {
+										Text      string `xml:",chardata"`
+										ClassCode string `xml:"classCode,attr"`
+									} `xml:"contactParty"`
+								} `xml:"providerOrganization"`
+								SubjectOf1 struct {
+									Text                  string `xml:",chardata"`
+									TypeCode              string `xml:"typeCode,attr"`
+									QueryMatchObservation struct {
+										Text      string `xml:",chardata"`
+										ClassCode string `xml:"classCode,attr"`
+										MoodCode  string `xml:"moodCode,attr"`
+										Code      struct {
+											Text       string `xml:",chardata"`
+											Code       string `xml:"code,attr"`
+											CodeSystem string `xml:"codeSystem,attr"`
+										} `xml:"code"`
+										Value struct {
+											Text  string `xml:",chardata"`
+											Xsi   string `xml:"xsi,attr"`
+											Value string `xml:"value,attr"`
+											Type  string `xml:"type,attr"`
+										} `xml:"value"`
+									} `xml:"queryMatchObservation"`
+								} `xml:"subjectOf1"`
+							} `xml:"patient"`
+						} `xml:"subject1"`
+						Custodian struct {
+							Text           string `xml:",chardata"`
+							TypeCode       string `xml:"typeCode,attr"`
+							AssignedEntity struct {
+								Text      string `xml:",chardata"`
+								ClassCode string `xml:"classCode,attr"`
+								ID        struct {
+									Text       string `xml:",chardata"`
+									Root       string `xml:"root,attr"`
+									NullFlavor string `xml:"nullFlavor,attr"`
+								} `xml:"id"`
+							} `xml:"assignedEntity"`
+						} `xml:"custodian"`
+					} `xml:"registrationEvent"`
+				} `xml:"subject"`
+				QueryAck struct {
+					Text    string `xml:",chardata"`
+					QueryId struct {
+						Text      string `xml:",chardata"`
+						Extension string `xml:"extension,attr"`
+						Root      string `xml:"root,attr"`
+					} `xml:"queryId"`
+					StatusCode struct {
+						Text string `xml:",chardata"`
+						Code string `xml:"code,attr"`
+					} `xml:"statusCode"`
+					QueryResponseCode struct {
+						Text string `xml:",chardata"`
+						Code string `xml:"code,attr"`
+					} `xml:"queryResponseCode"`
+					ResultTotalQuantity struct {
+						Text  string `xml:",chardata"`
+						Value string `xml:"value,attr"`
+					} `xml:"resultTotalQuantity"`
+					ResultCurrentQuantity struct {
+						Text  string `xml:",chardata"`
+						Value string `xml:"value,attr"`
+					} `xml:"resultCurrentQuantity"`
+					ResultRemainingQuantity struct {
+						Text  string `xml:",chardata"`
+						Value string `xml:"value,attr"`
+					} `xml:"resultRemainingQuantity"`
+				} `xml:"queryAck"`
+				QueryByParameter struct {
+					Text    string `xml:",chardata"`
+					QueryId struct {
+						Text      string `xml:",chardata"`
+						Extension string `xml:"extension,attr"`
+						Root      string `xml:"root,attr"`
+					} `xml:"queryId"`
+					StatusCode struct {
+						Text string `xml:",chardata"`
+						Code string `xml:"code,attr"`
+					} `xml:"statusCode"`
+					ResponseModalityCode struct {
+						Text string `xml:",chardata"`
+						Code string `xml:"code,attr"`
+					} `xml:"responseModalityCode"`
+					ResponsePriorityCode struct {
+						Text string `xml:",chardata"`
+						Code string `xml:"code,attr"`
+					} `xml:"responsePriorityCode"`
+					MatchCriterionList string `xml:"matchCriterionList"`
+					ParameterList      struct {
+						Text            string `xml:",chardata"`
+						LivingSubjectId struct {
+							Text  string `xml:",chardata"`
+							Value struct {
+								Text      string `xml:",chardata"`
+								Extension string `xml:"extension,attr"`
+							} `xml:"value"`
+							SemanticsText string `xml:"semanticsText"`
+						} `xml:"livingSubjectId"`
+					} `xml:"parameterList"`
+				} `xml:"queryByParameter"`
+			} `xml:"controlActProcess"`
+		} `xml:"PRPA_IN201306UV02"`
+	} `xml:"Body"`
+}
+type PIXv3Response struct {
+	XMLName xml.Name `xml:"Envelope"`
+	S       string   `xml:"S,attr"`
+	Env     string   `xml:"env,attr"`
+	Header  struct {
+		Action struct {
+			Xmlns string `xml:"xmlns,attr"`
+			S     string `xml:"S,attr"`
+			Env   string `xml:"env,attr"`
+		} `xml:"Action"`
+		MessageID struct {
+			Xmlns string `xml:"xmlns,attr"`
+			S     string `xml:"S,attr"`
+			Env   string `xml:"env,attr"`
+		} `xml:"MessageID"`
+		RelatesTo struct {
+			Xmlns string `xml:"xmlns,attr"`
+			S     string `xml:"S,attr"`
+			Env   string `xml:"env,attr"`
+		} `xml:"RelatesTo"`
+		To struct {
+			Xmlns string `xml:"xmlns,attr"`
+			S     string `xml:"S,attr"`
+			Env   string `xml:"env,attr"`
+		} `xml:"To"`
+	} `xml:"Header"`
+	Body struct {
+		PRPAIN201310UV02 struct {
+			Xmlns      string `xml:"xmlns,attr"`
+			ITSVersion string `xml:"ITSVersion,attr"`
+			ID         struct {
+				Extension string `xml:"extension,attr"`
+				Root      string `xml:"root,attr"`
+			} `xml:"id"`
+			CreationTime struct {
+				Value string `xml:"value,attr"`
+			} `xml:"creationTime"`
+			VersionCode struct {
+				Code string `xml:"code,attr"`
+			} `xml:"versionCode"`
+			InteractionId struct {
+				Extension string `xml:"extension,attr"`
+				Root      string `xml:"root,attr"`
+			} `xml:"interactionId"`
+			ProcessingCode struct {
+				Code string `xml:"code,attr"`
+			} `xml:"processingCode"`
+			ProcessingModeCode struct {
+				Code string `xml:"code,attr"`
+			} `xml:"processingModeCode"`
+			AcceptAckCode struct {
+				Code string `xml:"code,attr"`
+			} `xml:"acceptAckCode"`
+			Receiver struct {
+				TypeCode string `xml:"typeCode,attr"`
+				Device   struct {
+					ClassCode      string `xml:"classCode,attr"`
+					DeterminerCode string `xml:"determinerCode,attr"`
+					ID             struct {
+						AssigningAuthorityName string `xml:"assigningAuthorityName,attr"`
+						Root                   string `xml:"root,attr"`
+					} `xml:"id"`
+					AsAgent struct {
+						ClassCode               string `xml:"classCode,attr"`
+						RepresentedOrganization struct {
+							ClassCode      string `xml:"classCode,attr"`
+							DeterminerCode string `xml:"determinerCode,attr"`
+							ID             struct {
+								AssigningAuthorityName string `xml:"assigningAuthorityName,attr"`
+								Root                   string `xml:"root,attr"`
+							} `xml:"id"`
+						} `xml:"representedOrganization"`
+					} `xml:"asAgent"`
+				} `xml:"device"`
+			} `xml:"receiver"`
+			Sender struct {
+				TypeCode string `xml:"typeCode,attr"`
+				Device   struct {
+					ClassCode      string `xml:"classCode,attr"`
+					DeterminerCode string `xml:"determinerCode,attr"`
+					ID             struct {
+						Root string `xml:"root,attr"`
+					} `xml:"id"`
+					AsAgent struct {
+						ClassCode               string `xml:"classCode,attr"`
+						RepresentedOrganization struct {
+							ClassCode      string `xml:"classCode,attr"`
+							DeterminerCode string `xml:"determinerCode,attr"`
+							ID             struct {
+								Root string `xml:"root,attr"`
+							} `xml:"id"`
+						} `xml:"representedOrganization"`
+					} `xml:"asAgent"`
+				} `xml:"device"`
+			} `xml:"sender"`
+			Acknowledgement struct {
+				TypeCode struct {
+					Code string `xml:"code,attr"`
+				} `xml:"typeCode"`
+				TargetMessage struct {
+					ID struct {
+						Extension string `xml:"extension,attr"`
+						Root      string `xml:"root,attr"`
+					} `xml:"id"`
+				} `xml:"targetMessage"`
+				AcknowledgementDetail []HL7v3AcknowledgementDetail `xml:"acknowledgementDetail"`
+			} `xml:"acknowledgement"`
+			ControlActProcess struct {
+				ClassCode string `xml:"classCode,attr"`
+				MoodCode  string `xml:"moodCode,attr"`
+				Code      struct {
+					Code       string `xml:"code,attr"`
+					CodeSystem string `xml:"codeSystem,attr"`
+				} `xml:"code"`
+				Subject struct {
+					TypeCode          string `xml:"typeCode,attr"`
+					RegistrationEvent struct {
+						ClassCode string `xml:"classCode,attr"`
+						MoodCode  string `xml:"moodCode,attr"`
+						ID        struct {
+							NullFlavor string `xml:"nullFlavor,attr"`
+						} `xml:"id"`
+						StatusCode struct {
+							Code string `xml:"code,attr"`
+						} `xml:"statusCode"`
+						Subject1 struct {
+							TypeCode string `xml:"typeCode,attr"`
+							Patient  struct {
+								ClassCode string `xml:"classCode,attr"`
+								ID        []struct {
+									Extension              string `xml:"extension,attr"`
+									Root                   string `xml:"root,attr"`
+									AssigningAuthorityName string `xml:"assigningAuthorityName,attr"`
+								} `xml:"id"`
+								StatusCode struct {
+									Code string `xml:"code,attr"`
+								} `xml:"statusCode"`
+								PatientPerson struct {
+									ClassCode      string `xml:"classCode,attr"`
+									DeterminerCode string `xml:"determinerCode,attr"`
+									Name           struct {
+										Given  string `xml:"given"`
+										Family string `xml:"family"`
+									} `xml:"name"`
+								} `xml:"patientPerson"`
+							} `xml:"patient"`
+						} `xml:"subject1"`
+						Custodian struct {
+							TypeCode       string `xml:"typeCode,attr"`
+							AssignedEntity struct {
+								ClassCode string `xml:"classCode,attr"`
+								ID        struct {
+									Root string `xml:"root,attr"`
+								} `xml:"id"`
+							} `xml:"assignedEntity"`
+						} `xml:"custodian"`
+					} `xml:"registrationEvent"`
+				} `xml:"subject"`
+				QueryAck struct {
+					QueryId struct {
+						Extension string `xml:"extension,attr"`
+						Root      string `xml:"root,attr"`
+					} `xml:"queryId"`
+					StatusCode struct {
+						Code string `xml:"code,attr"`
+					} `xml:"statusCode"`
+					QueryResponseCode struct {
+						Code string `xml:"code,attr"`
+					} `xml:"queryResponseCode"`
+					ResultTotalQuantity struct {
+						Value string `xml:"value,attr"`
+					} `xml:"resultTotalQuantity"`
+					ResultCurrentQuantity struct {
+						Value string `xml:"value,attr"`
+					} `xml:"resultCurrentQuantity"`
+					ResultRemainingQuantity struct {
+						Value string `xml:"value,attr"`
+					} `xml:"resultRemainingQuantity"`
+				} `xml:"queryAck"`
+				QueryByParameter struct {
+					QueryId struct {
+						Extension string `xml:"extension,attr"`
+						Root      string `xml:"root,attr"`
+					} `xml:"queryId"`
+					StatusCode struct {
+						Code string `xml:"code,attr"`
+					} `xml:"statusCode"`
+					ResponsePriorityCode struct {
+						Code string `xml:"code,attr"`
+					} `xml:"responsePriorityCode"`
+					ParameterList struct {
+						PatientIdentifier struct {
+							Value struct {
+								AssigningAuthorityName string `xml:"assigningAuthorityName,attr"`
+								Extension              string `xml:"extension,attr"`
+								Root                   string `xml:"root,attr"`
+							} `xml:"value"`
+							SemanticsText string `xml:"semanticsText"`
+						} `xml:"patientIdentifier"`
+					} `xml:"parameterList"`
+				} `xml:"queryByParameter"`
+			} `xml:"controlActProcess"`
+		} `xml:"PRPA_IN201310UV02"`
+	} `xml:"Body"`
+}
+type HL7v3AckResponse struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		MCCIIN000002UV01 struct {
+			ID struct {
+				Extension string `xml:"extension,attr"`
+				Root      string `xml:"root,attr"`
+			} `xml:"id"`
+			InteractionId struct {
+				Extension string `xml:"extension,attr"`
+				Root      string `xml:"root,attr"`
+			} `xml:"interactionId"`
+			Acknowledgement struct {
+				TypeCode struct {
+					Code string `xml:"code,attr"`
+				} `xml:"typeCode"`
+				TargetMessage struct {
+					ID struct {
+						Extension string `xml:"extension,attr"`
+						Root      string `xml:"root,attr"`
+					} `xml:"id"`
+				} `xml:"targetMessage"`
+				AcknowledgementDetail []HL7v3AcknowledgementDetail `xml:"acknowledgementDetail"`
+			} `xml:"acknowledgement"`
+		} `xml:"MCCI_IN000002UV01"`
+	} `xml:"Body"`
+}
+
+// HL7v3AcknowledgementDetail is an acknowledgementDetail of a HL7 v3 acknowledgement. TypeCode is E (error), W (warning) or I (information)
+type HL7v3AcknowledgementDetail struct {
+	TypeCode string `xml:"typeCode,attr"`
+	Code     struct {
+		Code        string `xml:"code,attr"`
+		CodeSystem  string `xml:"codeSystem,attr"`
+		DisplayName string `xml:"displayName,attr"`
+	} `xml:"code"`
+	Text     string `xml:"text"`
+	Location string `xml:"location"`
+}
+type PIXmResponse struct {
+	ResourceType string `json:"resourceType"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Total        int    `json:"total"`
+	Link         []struct {
+		Relation string `json:"relation"`
+		URL      string `json:"url"`
+	} `json:"link"`
+	Entry []struct {
+		FullURL  string `json:"fullUrl"`
+		Resource struct {
+			ResourceType string `json:"resourceType"`
+			ID           string `json:"id"`
+			Identifier   []struct {
+				Use    string `json:"use,omitempty"`
+				System string `json:"system"`
+				Value  string `json:"value"`
+			} `json:"identifier"`
+			Active bool `json:"active"`
+			Name   []struct {
+				Use    string   `json:"use"`
+				Family string   `json:"family"`
+				Given  []string `json:"given"`
+			} `json:"name"`
+			Telecom []struct {
+				System string `json:"system"`
+				Value  string `json:"value"`
+				Use    string `json:"use,omitempty"`
+			} `json:"telecom,omitempty"`
+			Gender    string `json:"gender"`
+			BirthDate string `json:"birthDate"`
+			Address   []struct {
+				Use        string   `json:"use"`
+				Line       []string `json:"line"`
+				City       string   `json:"city"`
+				PostalCode string   `json:"postalCode"`
+				Country    string   `json:"country"`
+			} `json:"address"`
+		} `json:"resource"`
+	} `json:"entry"`
+}
+
+// PIXmParametersResponse is the FHIR Parameters resource returned by an IHE ITI-83 $ihe-pix operation. If the operation fails, the Issue's from the returned OperationOutcome resource are set
+type PIXmParametersResponse struct {
+	ResourceType string `json:"resourceType"`
+	Parameter    []struct {
+		Name            string `json:"name"`
+		ValueIdentifier struct {
+			Use    string `json:"use,omitempty"`
+			System string `json:"system"`
+			Value  string `json:"value"`
+		} `json:"valueIdentifier,omitempty"`
+		ValueReference struct {
+			Reference string `json:"reference"`
+		} `json:"valueReference,omitempty"`
+	} `json:"parameter,omitempty"`
+	Issue []struct {
+		Severity    string `json:"severity"`
+		Code        string `json:"code"`
+		Diagnostics string `json:"diagnostics,omitempty"`
+	} `json:"issue,omitempty"`
+}
+type TUKPatient struct {
+	PIDOID        string `json:"pidoid"`
+	PID           string `json:"pid"`
+	REGOID        string `json:"regoid"`
+	REGID         string `json:"regid"`
+	NHSOID        string `json:"nhsoid"`
+	NHSID         string `json:"nhsid"`
+	GivenName     string `json:"givenname"`
+	FamilyName    string `json:"familyname"`
+	Gender        string `json:"gender"`
+	BirthDate     string `json:"birthdate"`
+	Street        string `json:"street"`
+	Town          string `json:"town"`
+	City          string `json:"city"`
+	State         string `json:"state"`
+	Country       string `json:"country"`
+	Zip           string `json:"zip"`
+	Phone         string `json:"phone"`
+	Email         string `json:"email"`
+	MaritalStatus string `json:"maritalstatus"`
+	Deceased      bool   `json:"deceased"`
+	MultipleBirth bool   `json:"multiplebirth"`
+	Community     string `json:"community,omitempty"`
+}
+type PDQInterface interface {
+	pdq(ctx context.Context) error
+}
+
+var (
+	DebugMode = false
+)
+
+const (
+	pdqv3ContinuationTemplate   = "pdqv3continuation"
+	pixv3FeedTemplate           = "pixv3feed"
+	pdqv3DefaultContinuationQty = 10
+	pdqmMaxPages                = 20
+)
+
+// New_Transaction performs the pdq. If the pdq fails or finds no patient, the returned error can be tested with errors.Is for ErrInvalidRequest, ErrNotFound and ErrTimeout
+// and with errors.As for an AckError, SOAPFaultError or HTTPStatusError. A pdqv3 Cancel request returns nil when acknowledged
+func New_Transaction(i PDQInterface) error {
+	return New_TransactionWithContext(context.Background(), i)
+}
+
+// New_TransactionWithContext performs the pdq using ctx for the requests sent to the pdq server, so the pdq is cancelled if ctx is cancelled or reaches its deadline before the pdq Timeout.
+// The Timeout applies to the whole pdq, including every page of a PDQm search and every XCPD gateway request
+func New_TransactionWithContext(ctx context.Context, i PDQInterface) error {
+	return i.pdq(ctx)
+}
+func (i *PDQQuery) pdq(ctx context.Context) error {
+	i.ctx = ctx
+	if err := i.setPDQ_ID(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(i.Timeout)*time.Second)
+	defer cancel()
+	i.ctx = ctx
+	if err := i.setPatient(); err != nil {
+		return getTimeoutError(err)
+	}
+	if i.Count == 0 && !i.Cancel {
+		return i.newNotFoundError()
+	}
+	return nil
+}
+func (i *PDQQuery) setPDQ_ID() error {
+	if i.Server_URL == "" {
+		return newInvalidRequestError("pdq server url is not set")
+	}
+
+	if i.REG_OID == "" {
+		if os.Getenv(tukcnst.XDSDOMAIN) == "" {
+			return newInvalidRequestError("reg oid is not set")
+		}
+	}
+	if i.Timeout == 0 {
+		i.Timeout = 5
+	}
+	if i.NHS_OID == "" {
+		i.NHS_OID = tukcnst.NHS_OID_DEFAULT
+	}
+	if i.Continuation_Token != "" || i.Cancel {
+		return i.setPDQv3Continuation()
+	}
+	if i.Initial_Quantity > 0 || i.Server_Mode == tukcnst.PDQ_SERVER_TYPE_IHE_XCPD {
+		i.Cache = false
+	}
+	if i.MRN_ID != "" && i.MRN_OID != "" {
+		i.Used_PID = i.MRN_ID
+		i.Used_PID_OID = i.MRN_OID
+	} else {
+		if i.NHS_ID != "" {
+			i.Used_PID = i.NHS_ID
+			i.Used_PID_OID = i.NHS_OID
+		} else {
+			if i.REG_ID != "" && i.REG_OID != "" {
+				i.Used_PID = i.REG_ID
+				i.Used_PID_OID = i.REG_OID
+			}
+		}
+	}
+	// responses are cached by patient id, so a query filtered by demographics is never cached or answered from the cache
+	if i.isDemographicQuery() {
+		i.Cache = false
+	}
+	if i.Used_PID == "" || i.Used_PID_OID == "" {
+		if i.isDemographicQuery() {
+			l(fmt.Sprintf("No suitable id and oid found. Performing %s demographic query", i.Server_Mode), true)
+			i.Used_PID, i.Used_PID_OID = "", ""
+			return nil
+		}
+		return newInvalidRequestError("no suitable id and oid input values found which can be used for pdq query")
+	}
+	return nil
+}
+
+// setPDQv3Continuation validates a PDQv3 continuation or cancel request. The Initial_Quantity is used as the continuation quantity
+func (i *PDQQuery) setPDQv3Continuation() error {
+	if i.Server_Mode != tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3 {
+		return newInvalidRequestError("query continuation and cancel are only supported by pdqv3 servers")
+	}
+	if i.Continuation_Token == "" {
+		return newInvalidRequestError("continuation token is not set")
+	}
+	if i.Initial_Quantity == 0 {
+		i.Initial_Quantity = pdqv3DefaultContinuationQty
+	}
+	i.Cache = false
+	return i.setContinuationToken()
+}
+
+// isDemographicQuery returns true if the pdq is a PDQv3, PDQv2, PDQm or XCPD query with at least one of the FamilyName, GivenName, BirthDate, Gender or Zip search values set
+func (i *PDQQuery) isDemographicQuery() bool {
+	switch i.Server_Mode {
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, tukcnst.PDQ_SERVER_TYPE_IHE_PDQM, tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2, tukcnst.PDQ_SERVER_TYPE_IHE_XCPD:
+		return i.FamilyName != "" || i.GivenName != "" || i.BirthDate != "" || i.Gender != "" || i.Zip != ""
+	}
+	return false
+}
+func (i *PDQQuery) setPatient() error {
+	if i.Cache && i.Server_Mode != tukcnst.PDQ_SERVER_TYPE_CGL {
+		if entry, ok := pat_cache.Get(i.getContext(), i.cacheKey()); ok {
+			l(fmt.Sprintf("Cache entry found for %s Patient ID %s %s", i.Server_Mode, i.Used_PID, i.Used_PID_OID), true)
+			err := i.setCachedPatients(entry)
+			if err == nil {
+				if entry.Stale {
+					i.refreshCachedPatient()
+				}
+				return nil
+			}
+			log.Printf("Unable to use %s cache entry for Patient ID %s %s - %s", i.Server_Mode, i.Used_PID, i.Used_PID_OID, err.Error())
+			pat_cache.Delete(i.getContext(), i.cacheKey())
+		}
+	}
+	return i.queryPatient()
+}
+
+// queryPatient queries the pdq server and, if Cache is true, caches the response. A response that found no patient is cached as a Not_Found CacheEntry
+func (i *PDQQuery) queryPatient() error {
+	var err error
+	i.StatusCode = http.StatusOK
+	switch i.Server_Mode {
+	case tukcnst.PDQ_SERVER_TYPE_CGL:
+		httpReq := tukhttp.CGLRequest{X_Api_Key: i.CGL_X_Api_Key, Timeout: i.Timeout}
+		if httpReq.Request, err = i.getCGLURL(); err != nil {
+			return err
+		}
+		i.Request = []byte(httpReq.Request)
+		if err = tukhttp.NewRequestWithContext(i.getContext(), &httpReq); err == nil {
+			if httpReq.StatusCode == http.StatusOK {
+				if err = json.Unmarshal(httpReq.Response, &i.CGLUserResponse); err == nil {
+					i.addPatient(newCGLPatient(i.CGLUserResponse, i.NHS_OID))
+				}
+			}
+		}
+		i.Response = httpReq.Response
+		i.StatusCode = httpReq.StatusCode
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3:
+		if err = i.newIHESOAPTemplateRequest(tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3, tukcnst.SOAP_ACTION_PIXV3_Request); err == nil {
+			err = i.setPIXv3Patient()
+		}
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3:
+		switch {
+		case i.Cancel:
+			if err = i.newIHESOAPTemplateRequest(pdqv3ContinuationTemplate, tukcnst.SOAP_ACTION_PDQV3_Cancel_Request); err == nil {
+				err = i.setHL7v3Ack()
+			}
+		case i.Continuation_Token != "":
+			if err = i.newIHESOAPTemplateRequest(pdqv3ContinuationTemplate, tukcnst.SOAP_ACTION_PDQV3_Continuation_Request); err == nil {
+				err = i.setPDQv3Patients()
+			}
+		default:
+			if err = i.newIHESOAPTemplateRequest(tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, tukcnst.SOAP_ACTION_PDQV3_Request); err == nil {
+				err = i.setPDQv3Patients()
+			}
+		}
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PIXM_ITI83:
+		httpReq := tukhttp.PIXmOpRequest{
+			URL:           i.Server_URL,
+			PID_OID:       i.Used_PID_OID,
+			PID:           i.Used_PID,
+			TargetSystems: i.Target_Systems,
+			Timeout:       i.Timeout,
+		}
+		err = tukhttp.NewRequestWithContext(i.getContext(), &httpReq)
+		i.Request = []byte(httpReq.URL)
+		i.Response = httpReq.Response
+		i.StatusCode = httpReq.StatusCode
+		if err == nil {
+			err = i.setPIXmParametersPatient()
+		}
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2:
+		err = i.newPDQv2Query()
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PIXV2:
+		err = i.newPIXv2Query()
+	case tukcnst.PDQ_SERVER_TYPE_IHE_XCPD:
+		err = i.newXCPDQuery()
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PDQM:
+		err = i.newPDQmQuery()
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PIXM:
+		i.Request = []byte(i.Server_URL)
+		httpReq := tukhttp.PIXmRequest{
+			URL:     i.Server_URL,
+			PID_OID: i.Used_PID_OID,
+			PID:     i.Used_PID,
+			Timeout: i.Timeout,
+		}
+		err = tukhttp.NewRequestWithContext(i.getContext(), &httpReq)
+		i.Response = httpReq.Response
+		i.StatusCode = httpReq.StatusCode
+		if err == nil {
+			if i.StatusCode != http.StatusOK {
+				err = i.newHTTPStatusError()
+			} else {
+				err = i.setPIXmBundlePatients()
+			}
+		}
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+	// a response with remaining results refers to a server side query that expires, so it is not cached
+	if i.Cache && i.Server_Mode != tukcnst.PDQ_SERVER_TYPE_CGL && i.Continuation_Token == "" {
+		pat_cache.Set(i.getContext(), i.cacheKey(), CacheEntry{Response: i.Response, Not_Found: i.Count == 0, Created: time.Now()})
+	}
+	return nil
+}
+
+// setPIXv3Patient unmarshals the PIXv3 response and adds a TUKPatient with the patient identifiers and name if the patient is known to the PIX manager
+func (i *PDQQuery) setPIXv3Patient() error {
+	if err := xml.Unmarshal(i.Response, &i.PIXv3Response); err != nil {
+		return err
+	}
+	if i.PIXv3Response.Body.PRPAIN201310UV02.Acknowledgement.TypeCode.Code != "AA" {
+		return newHL7v3AckError(i.PIXv3Response.Body.PRPAIN201310UV02.Acknowledgement.TypeCode.Code, i.PIXv3Response.Body.PRPAIN201310UV02.Acknowledgement.AcknowledgementDetail)
+	}
+	if total, _ := strconv.Atoi(i.PIXv3Response.Body.PRPAIN201310UV02.ControlActProcess.QueryAck.ResultTotalQuantity.Value); total > 0 {
+		pat := TUKPatient{
+			PIDOID: i.MRN_OID,
+			PID:    i.MRN_ID,
+			REGOID: i.REG_OID,
+			REGID:  i.REG_ID,
+			NHSOID: i.NHS_OID,
+			NHSID:  i.NHS_ID,
+		}
+		pat.GivenName = i.PIXv3Response.Body.PRPAIN201310UV02.ControlActProcess.Subject.RegistrationEvent.Subject1.Patient.PatientPerson.Name.Given
+		pat.FamilyName = i.PIXv3Response.Body.PRPAIN201310UV02.ControlActProcess.Subject.RegistrationEvent.Subject1.Patient.PatientPerson.Name.Family
+		for _, pid := range i.PIXv3Response.Body.PRPAIN201310UV02.ControlActProcess.Subject.RegistrationEvent.Subject1.Patient.ID {
+			switch pid.Root {
+			case i.REG_OID:
+				pat.REGID = pid.Extension
+			case i.NHS_OID:
+				pat.NHSID = pid.Extension
+			case i.MRN_OID:
+				pat.PID = pid.Extension
+				pat.PIDOID = i.MRN_OID
+			}
+		}
+		i.addPatient(pat)
+	}
+	return nil
+}
+
+// getCGLURL returns the CGL server url for the NHS_ID. If the Server_URL query ends with a parameter name (eg https://public-api.criisdev.org.uk/api/v1/user?NHS_number=) the NHS_ID is set as that parameter,
+// otherwise the NHS_ID is added to the url path. The NHS_ID must be a 10 digit NHS number
+func (i *PDQQuery) getCGLURL() (string, error) {
+	if !isNHSNumber(i.NHS_ID) {
+		return "", newInvalidRequestError("cgl query nhs id must be a 10 digit nhs number")
+	}
+	base, query, _ := strings.Cut(i.Server_URL, "?")
+	if param := query[strings.LastIndex(query, "&")+1:]; strings.HasSuffix(param, "=") {
+		return tukhttp.NewURL(base+"?"+strings.TrimSuffix(query, param), "", url.Values{strings.TrimSuffix(param, "="): {i.NHS_ID}})
+	}
+	return tukhttp.NewURL(i.Server_URL, i.NHS_ID, nil)
+}
+
+// isNHSNumber returns true if id is a 10 digit NHS number
+func isNHSNumber(id string) bool {
+	if len(id) != 10 {
+		return false
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// setPIXmBundlePatients unmarshals a PIXm or PDQm Patient search Bundle response and adds a TUKPatient for each patient entry
+func (i *PDQQuery) setPIXmBundlePatients() error {
+	if err := json.Unmarshal(i.Response, &i.PIXmResponse); err != nil {
+		return err
+	}
+	log.Printf("%v Patient Entries in Response", len(i.PIXmResponse.Entry))
+	i.setPIXmPatients()
+	return nil
+}
+
+// isSameOrigin returns true if link has the same scheme and host as server_url
+func isSameOrigin(link string, server_url string) bool {
+	linkURL, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	serverURL, err := url.Parse(server_url)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(linkURL.Scheme, serverURL.Scheme) && strings.EqualFold(linkURL.Host, serverURL.Host)
+}
+
+// newPDQmQuery performs an IHE ITI-78 PDQm Patient search using the Used_PID and any of the FamilyName, GivenName, BirthDate, Gender and Zip values set. Initial_Quantity sets the search page size.
+// The Bundle next links are followed, up to a maximum of 20 pages, and the entries from all pages are merged into the PIXmResponse. A next link to a different scheme or host than the Server_URL is not followed.
+// If more than one page is returned, Response is set to the merged PIXmResponse
+func (i *PDQQuery) newPDQmQuery() error {
+	params := url.Values{}
+	if i.Used_PID != "" {
+		params.Set("identifier", tukcnst.URN_OID_PREFIX+i.Used_PID_OID+"|"+i.Used_PID)
+	}
+	if i.FamilyName != "" {
+		params.Set("family", i.FamilyName)
+	}
+	if i.GivenName != "" {
+		params.Set("given", i.GivenName)
+	}
+	if i.BirthDate != "" {
+		params.Set("birthdate", getFhirDate(i.BirthDate))
+	}
+	if i.Gender != "" {
+		params.Set("gender", getFhirGender(getHL7Gender(i.Gender)))
+	}
+	if i.Zip != "" {
+		params.Set("address-postalcode", i.Zip)
+	}
+	if i.Initial_Quantity > 0 {
+		params.Set("_count", strconv.Itoa(i.Initial_Quantity))
+	}
+	params.Set("_format", tukcnst.JSON)
+	next, err := tukhttp.NewURL(strings.TrimSuffix(i.Server_URL, "/"), "", params)
+	if err != nil {
+		return err
+	}
+	i.Request = []byte(next)
+	i.PIXmResponse = &PIXmResponse{}
+	pages := 0
+	for ; next != "" && pages < pdqmMaxPages; pages++ {
+		httpReq := tukhttp.FHIRRequest{
+			URL:     next,
+			Timeout: i.Timeout,
+		}
+		err := tukhttp.NewRequestWithContext(i.getContext(), &httpReq)
+		i.Response = httpReq.Response
+		i.StatusCode = httpReq.StatusCode
+		if err != nil {
+			return err
+		}
+		if i.StatusCode != http.StatusOK {
+			return i.newHTTPStatusError()
+		}
+		bundle := PIXmResponse{}
+		if err = json.Unmarshal(i.Response, &bundle); err != nil {
+			return err
+		}
+		if pages == 0 {
+			*i.PIXmResponse = bundle
+		} else {
+			i.PIXmResponse.Entry = append(i.PIXmResponse.Entry, bundle.Entry...)
+		}
+		if next = bundle.getLink("next"); next != "" && !isSameOrigin(next, i.Server_URL) {
+			l(fmt.Sprintf("PDQm next link %s is not on the pdq server %s. Remaining pages ignored", next, i.Server_URL), false)
+			next = ""
+		}
+	}
+	if next != "" {
+		l(fmt.Sprintf("PDQm search returned more than %v pages. Remaining pages ignored", pdqmMaxPages), false)
+	}
+	if pages > 1 {
+		i.Response, _ = json.Marshal(i.PIXmResponse)
+	}
+	log.Printf("%v Patient Entries in %v Response Pages", len(i.PIXmResponse.Entry), pages)
+	i.setPIXmPatients()
+	return nil
+}
+
+// getLink returns the url of the Bundle link with the relation rel, or an empty string if the Bundle has no link with that relation
+func (i *PIXmResponse) getLink(rel string) string {
+	for _, link := range i.Link {
+		if link.Relation == rel {
+			return link.URL
+		}
+	}
+	return ""
+}
+
+// setPIXmPatients adds a TUKPatient for each patient entry in the PIXm response bundle
+func (i *PDQQuery) setPIXmPatients() {
+	for _, entry := range i.PIXmResponse.Entry {
+		pat := TUKPatient{
+			REGOID: i.REG_OID,
+			NHSOID: i.NHS_OID,
+		}
+		for _, id := range entry.Resource.Identifier {
+			if id.System == tukcnst.URN_OID_PREFIX+i.REG_OID {
+				pat.REGID = id.Value
+				log.Printf("Set Reg ID %s %s", pat.REGID, pat.REGOID)
+			}
+			if id.Use == "usual" {
+				pat.PID = id.Value
+				pat.PIDOID = strings.TrimPrefix(id.System, tukcnst.URN_OID_PREFIX)
+				log.Printf("Set PID %s %s", pat.PID, pat.PIDOID)
+			}
+			if id.System == tukcnst.URN_OID_PREFIX+i.NHS_OID {
+				pat.NHSID = id.Value
+				log.Printf("Set NHS ID %s %s", pat.NHSID, pat.NHSOID)
+			}
+		}
+		gn := ""
+		for _, name := range entry.Resource.Name {
+			for _, n := range name.Given {
+				gn = gn + n + " "
+			}
+		}
+		pat.GivenName = strings.TrimSuffix(gn, " ")
+		if len(entry.Resource.Name) > 0 {
+			pat.FamilyName = entry.Resource.Name[0].Family
+		}
+		pat.BirthDate = getHL7Date(entry.Resource.BirthDate)
+		pat.Gender = entry.Resource.Gender
+		if len(entry.Resource.Address) > 0 {
+			pat.Zip = entry.Resource.Address[0].PostalCode
+			if len(entry.Resource.Address[0].Line) > 0 {
+				pat.Street = entry.Resource.Address[0].Line[0]
+				if len(entry.Resource.Address[0].Line) > 1 {
+					pat.Town = entry.Resource.Address[0].Line[1]
+				}
+			}
+			pat.City = entry.Resource.Address[0].City
+			pat.Country = entry.Resource.Address[0].Country
+		}
+		for _, telecom := range entry.Resource.Telecom {
+			switch {
+			case telecom.System == "phone" && pat.Phone == "":
+				pat.Phone = telecom.Value
+			case telecom.System == "email" && pat.Email == "":
+				pat.Email = telecom.Value
+			}
+		}
+		i.addPatient(pat)
+	}
+}
+
+// setPIXmParametersPatient sets the patient identifiers from the targetIdentifier parameters of an ITI-83 response. A target identifier is mapped to the NHS, Regional or MRN id by its system oid.
+// If MRN_OID is not set, the first target identifier from any other domain is returned as the PID with its oid
+func (i *PDQQuery) setPIXmParametersPatient() error {
+	err := json.Unmarshal(i.Response, &i.PIXmParametersResponse)
+	if i.StatusCode != http.StatusOK {
+		statuserr := i.newHTTPStatusError()
+		switch i.StatusCode {
+		case http.StatusNotFound:
+			l("Source identifier not found - "+statuserr.Detail, true)
+			return nil
+		case http.StatusForbidden, http.StatusBadRequest:
+			return newInvalidRequestError(strings.TrimSuffix("target system not recognised by pixm server - "+statuserr.Detail, " - "))
+		}
+		return statuserr
+	}
+	if err != nil {
+		return err
+	}
+	pat := TUKPatient{
+		PIDOID: i.MRN_OID,
+		PID:    i.MRN_ID,
+		REGOID: i.REG_OID,
+		REGID:  i.REG_ID,
+		NHSOID: i.NHS_OID,
+		NHSID:  i.NHS_ID,
+	}
+	found := false
+	mrn := false
+	for _, param := range i.PIXmParametersResponse.Parameter {
+		switch param.Name {
+		case "targetIdentifier":
+			found = true
+			oid := strings.TrimPrefix(param.ValueIdentifier.System, tukcnst.URN_OID_PREFIX)
+			switch {
+			case oid == i.REG_OID:
+				pat.REGID = param.ValueIdentifier.Value
+			case oid == i.NHS_OID:
+				pat.NHSID = param.ValueIdentifier.Value
+			case !mrn && (oid == i.MRN_OID || i.MRN_OID == ""):
+				mrn = true
+				pat.PID = param.ValueIdentifier.Value
+				pat.PIDOID = oid
+			}
+		case "targetId":
+			found = true
+		}
+	}
+	if found {
+		i.addPatient(pat)
+	}
+	return nil
+}
+func newCGLPatient(cgl *CGLUserResponse, nhsoid string) TUKPatient {
+	details := cgl.Data.Client.BasicDetails
+	return TUKPatient{
+		NHSOID:     nhsoid,
+		NHSID:      details.NhsNumber,
+		GivenName:  details.Name.Given,
+		FamilyName: details.Name.Family,
+		Gender:     details.SexAtBirth,
+		BirthDate:  getHL7Date(details.BirthDate),
+		Street:     details.Address.AddressLine1,
+		Town:       details.Address.AddressLine2,
+		City:       details.Address.AddressLine3,
+		Zip:        details.Address.PostCode,
+	}
+}
+
+// addPatient appends pat to the matched Patients and sets Count to the number of matched patients. The PDQQuery patient fields are set from the first matched patient
+func (i *PDQQuery) addPatient(pat TUKPatient) {
+	if i.Patients == nil {
+		i.Patients = &[]TUKPatient{}
+	}
+	*i.Patients = append(*i.Patients, pat)
+	i.Count = len(*i.Patients)
+	if i.Count > 1 {
+		return
+	}
+	setIfNotEmpty(&i.MRN_ID, pat.PID)
+	setIfNotEmpty(&i.MRN_OID, pat.PIDOID)
+	setIfNotEmpty(&i.NHS_ID, pat.NHSID)
+	setIfNotEmpty(&i.REG_ID, pat.REGID)
+	setIfNotEmpty(&i.GivenName, pat.GivenName)
+	setIfNotEmpty(&i.FamilyName, pat.FamilyName)
+	setIfNotEmpty(&i.BirthDate, pat.BirthDate)
+	setIfNotEmpty(&i.Gender, pat.Gender)
+	setIfNotEmpty(&i.Zip, pat.Zip)
+	setIfNotEmpty(&i.Street, pat.Street)
+	setIfNotEmpty(&i.Town, pat.Town)
+	setIfNotEmpty(&i.City, pat.City)
+	setIfNotEmpty(&i.Country, pat.Country)
+	setIfNotEmpty(&i.Phone, pat.Phone)
+	setIfNotEmpty(&i.Email, pat.Email)
+}
+
+// templateFuncMap returns the tukutil template functions along with the hl7 conversion functions used in the PDQ SOAP templates
+func templateFuncMap() template.FuncMap {
+	funcs := tukutil.TemplateFuncMap()
+	funcs["hl7gender"] = getHL7Gender
+	funcs["hl7date"] = getHL7Date
+	funcs["pdqv2params"] = getPDQv2QueryParameters
+	funcs["hl7v2"] = escapeHL7v2
+	funcs["xml"] = escapeXML
+	return funcs
+}
+
+// escapeXML returns val with the xml special characters, including both quotes, replaced by their character references so it can be used in xml text and attribute values
+func escapeXML(val string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(val))
+	return b.String()
+}
+
+// getHL7Gender returns the hl7 v3 administrative gender code for a fhir administrative gender or hl7 gender code
+func getHL7Gender(gender string) string {
+	switch strings.ToLower(gender) {
+	case "male", "m":
+		return "M"
+	case "female", "f":
+		return "F"
+	case "other", "un":
+		return "UN"
+	}
+	return "U"
+}
+
+// getHL7Date returns date in hl7 yyyyMMdd format. Date can be either yyyyMMdd or yyyy-MM-dd and any time that follows the date (eg yyyyMMddHHmmss or yyyy-MM-ddTHH:mm:ss) is removed
+func getHL7Date(date string) string {
+	date = strings.ReplaceAll(strings.TrimSpace(date), "-", "")
+	if len(date) > 8 && strings.Trim(date[:8], "0123456789") == "" {
+		return date[:8]
+	}
+	return date
+}
+
+// getFhirDate returns date in fhir yyyy-MM-dd format. Date can be either yyyyMMdd or yyyy-MM-dd
+func getFhirDate(date string) string {
+	if len(date) == 8 && !strings.Contains(date, "-") {
+		return date[0:4] + "-" + date[4:6] + "-" + date[6:8]
+	}
+	return date
+}
+
+// getFhirGender returns the fhir administrative gender for a hl7 v2 or v3 administrative gender code
+func getFhirGender(code string) string {
+	switch strings.ToUpper(code) {
+	case "M":
+		return "male"
+	case "F":
+		return "female"
+	case "UN", "O", "A":
+		return "other"
+	case "U", "":
+		return "unknown"
+	}
+	return strings.ToLower(code)
+}
+func setIfNotEmpty(field *string, val string) {
+	if val != "" {
+		*field = val
+	}
+}
+
+// setPDQv3Patients unmarshals the PDQv3 response, adds a TUKPatient for each matched patient and sets the Continuation_Token if further results remain
+func (i *PDQQuery) setPDQv3Patients() error {
+	if err := xml.Unmarshal(i.Response, &i.PDQv3Response); err != nil {
+		return err
+	}
+	if i.PDQv3Response.Body.PRPAIN201306UV02.Acknowledgement.TypeCode.Code != "AA" {
+		return newHL7v3AckError(i.PDQv3Response.Body.PRPAIN201306UV02.Acknowledgement.TypeCode.Code, i.PDQv3Response.Body.PRPAIN201306UV02.Acknowledgement.AcknowledgementDetail)
+	}
+	for _, subject := range i.PDQv3Response.Body.PRPAIN201306UV02.ControlActProcess.Subject {
+		pat := TUKPatient{
+			REGOID: i.REG_OID,
+			NHSOID: i.NHS_OID,
+		}
+		for _, pid := range subject.RegistrationEvent.Subject1.Patient.ID {
+			switch pid.Root {
+			case i.REG_OID:
+				pat.REGID = pid.Extension
+			case i.NHS_OID:
+				pat.NHSID = pid.Extension
+			case i.MRN_OID:
+				pat.PID = pid.Extension
+				pat.PIDOID = i.MRN_OID
+			}
+		}
+		person := subject.RegistrationEvent.Subject1.Patient.PatientPerson
+		pat.GivenName = strings.Join(person.Name.Given, " ")
+		pat.FamilyName = person.Name.Family
+		pat.Gender = getFhirGender(person.AdministrativeGenderCode.Code)
+		pat.BirthDate = getHL7Date(person.BirthTime.Value)
+		if len(person.Addr.StreetAddressLine) > 0 {
+			pat.Street = person.Addr.StreetAddressLine[0]
+			if len(person.Addr.StreetAddressLine) > 1 {
+				pat.Town = person.Addr.StreetAddressLine[1]
+			}
+		}
+		pat.City = person.Addr.City
+		pat.State = person.Addr.State
+		pat.Zip = person.Addr.PostalCode
+		pat.Country = person.Addr.Country
+		for _, telecom := range person.Telecom {
+			switch {
+			case strings.HasPrefix(telecom.Value, "tel:") && pat.Phone == "":
+				pat.Phone = strings.TrimPrefix(telecom.Value, "tel:")
+			case strings.HasPrefix(telecom.Value, "mailto:") && pat.Email == "":
+				pat.Email = strings.TrimPrefix(telecom.Value, "mailto:")
+			}
+		}
+		pat.MaritalStatus = person.MaritalStatusCode.Code
+		pat.Deceased, _ = strconv.ParseBool(person.DeceasedInd.Value)
+		pat.MultipleBirth, _ = strconv.ParseBool(person.MultipleBirthInd.Value)
+		i.addPatient(pat)
+	}
+	queryAck := i.PDQv3Response.Body.PRPAIN201306UV02.ControlActProcess.QueryAck
+	i.Remaining, _ = strconv.Atoi(queryAck.ResultRemainingQuantity.Value)
+	i.Continuation_Token = ""
+	if i.Remaining > 0 {
+		i.Continuation_Token = newContinuationToken(queryAck.QueryId.Root, queryAck.QueryId.Extension)
+		l(fmt.Sprintf("%v PDQv3 results remaining for query id %s", i.Remaining, queryAck.QueryId.Extension), true)
+	}
+	return nil
+}
+
+// setHL7v3Ack unmarshals a HL7 v3 MCCI_IN000002UV01 acknowledgement response and returns an error if the acknowledgement code is not AA or CA
+func (i *PDQQuery) setHL7v3Ack() error {
+	if err := xml.Unmarshal(i.Response, &i.HL7v3AckResponse); err != nil {
+		return err
+	}
+	if code := i.HL7v3AckResponse.Body.MCCIIN000002UV01.Acknowledgement.TypeCode.Code; code != "AA" && code != "CA" {
+		return newHL7v3AckError(code, i.HL7v3AckResponse.Body.MCCIIN000002UV01.Acknowledgement.AcknowledgementDetail)
+	}
+	return nil
+}
+
+// newContinuationToken returns an opaque token containing the PDQv3 query id root and extension which can be used to continue or cancel the query
+func newContinuationToken(root string, extension string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(root + "^" + extension))
+}
+
+// setContinuationToken sets the Query_ID_Root and Query_ID from the Continuation_Token
+func (i *PDQQuery) setContinuationToken() error {
+	tkn, err := base64.RawURLEncoding.DecodeString(i.Continuation_Token)
+	if err != nil {
+		return newInvalidRequestError("continuation token is not valid")
+	}
+	qid := strings.SplitN(string(tkn), "^", 2)
+	if len(qid) != 2 || qid[0] == "" || qid[1] == "" {
+		return newInvalidRequestError("continuation token is not valid")
+	}
+	i.Query_ID_Root = qid[0]
+	i.Query_ID = qid[1]
+	return nil
+}
+func (i *PDQQuery) newIHESOAPTemplateRequest(name string, soapaction string) error {
+	i.setDevices()
+	i.setMessageIDs(name)
+	if err := i.setTemplateRequest(name); err != nil {
+		return err
+	}
+	return i.newIHESOAPRequest(soapaction)
+}
+
+// setMessageIDs sets a new Message_ID, used as the WS-Addressing MessageID and HL7 v3 message id of the request, and, unless the request continues or cancels a pdqv3 query or is a pix feed, a new Query_ID
+func (i *PDQQuery) setMessageIDs(name string) {
+	i.Message_ID = tukutil.NewUuid()
+	switch name {
+	case pdqv3ContinuationTemplate, pixv3FeedTemplate:
+	default:
+		i.Query_ID = tukutil.NewUuid()
+	}
+}
+
+func (i *PDQQuery) newIHESOAPRequest(soapaction string) error {
+	httpReq := tukhttp.SOAPRequest{
+		URL:        i.Server_URL,
+		SOAPAction: soapaction,
+		Body:       i.Request,
+		Timeout:    i.Timeout,
+	}
+	err := tukhttp.NewRequestWithContext(i.getContext(), &httpReq)
+	i.Response = httpReq.Response
+	i.StatusCode = httpReq.StatusCode
+	if err != nil {
+		return err
+	}
+	if err = i.newSOAPFaultError(); err != nil {
+		return err
+	}
+	if i.StatusCode != http.StatusOK {
+		return i.newHTTPStatusError()
+	}
+	return i.checkRelatesTo()
+}
+
+// getContext returns the context of the transaction, or the background context if the PDQQuery is not part of a transaction
+func (i *PDQQuery) getContext() context.Context {
+	if i.ctx == nil {
+		return context.Background()
+	}
+	return i.ctx
+}
+func l(msg string, debug bool) {
+	if !debug {
+		log.Println(msg)
+	} else {
+		if DebugMode {
+			log.Println(msg)
+		}
+	}
+}
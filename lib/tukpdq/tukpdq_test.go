@@ -0,0 +1,377 @@
+package tukpdq
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+const testPDQv3Response = `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><S:Header><RelatesTo xmlns="http://www.w3.org/2005/08/addressing">{{RELATESTO}}</RelatesTo></S:Header><S:Body>` +
+	`<PRPA_IN201306UV02 xmlns="urn:hl7-org:v3" ITSVersion="XML_1.0"><acknowledgement><typeCode code="AA"/></acknowledgement><controlActProcess classCode="CACT" moodCode="EVN">` +
+	`<subject typeCode="SUBJ"><registrationEvent classCode="REG" moodCode="EVN"><subject1 typeCode="SBJ"><patient classCode="PAT">` +
+	`<id root="2.16.840.1.113883.2.1.4.1" extension="9999999468"/><id root="2.16.840.1.113883.2.1.3.31.2.1.1" extension="REG.1"/>` +
+	`<patientPerson><name><given>Nhs</given><given>A</given><family>Testpatient</family></name>` +
+	`<telecom value="mailto:nhs.testpatient@example.org"/><telecom use="HP" value="tel:01772 123456"/><telecom use="MC" value="tel:07700 900000"/>` +
+	`<administrativeGenderCode code="F"/><birthTime value="19620404120000"/><deceasedInd value="false"/><multipleBirthInd value="true"/>` +
+	`<addr><streetAddressLine>1 Preston Road</streetAddressLine><streetAddressLine>Fulwood</streetAddressLine><city>Preston</city><state>Lancashire</state><postalCode>PR1 1PR</postalCode><country>GBR</country></addr>` +
+	`<maritalStatusCode code="M"/></patientPerson></patient></subject1></registrationEvent></subject>` +
+	`<queryAck><queryId root="1.2.3" extension="query-1"/><resultRemainingQuantity value="{{REMAINING}}"/></queryAck></controlActProcess></PRPA_IN201306UV02></S:Body></S:Envelope>`
+
+var messageIDRegex = regexp.MustCompile(`<MessageID[^>]*>([^<]*)<`)
+
+// newSOAPStandIn returns a stand-in SOAP server that replies with rsp, with {{RELATESTO}} replaced by the request MessageID, and counts the requests received
+func newSOAPStandIn(t *testing.T, rsp string) (*httptest.Server, *int32) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		b, _ := io.ReadAll(r.Body)
+		relatesto := ""
+		if m := messageIDRegex.FindSubmatch(b); m != nil {
+			relatesto = string(m[1])
+		}
+		w.Write([]byte(strings.ReplaceAll(rsp, "{{RELATESTO}}", relatesto)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestGetCGLURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		server_url string
+		nhs_id     string
+		want       string
+		wantErr    error
+	}{
+		{"query param", "https://cgl.example.org/api/v1/user?NHS_number=", "9999999468", "https://cgl.example.org/api/v1/user?NHS_number=9999999468", nil},
+		{"last query param", "https://cgl.example.org/api/v1/user?tenant=a&NHS_number=", "9999999468", "https://cgl.example.org/api/v1/user?NHS_number=9999999468&tenant=a", nil},
+		{"path", "https://cgl.example.org/api/v1/user/", "9999999468", "https://cgl.example.org/api/v1/user/9999999468", nil},
+		{"dot dot", "https://cgl.example.org/api/v1/user/", "..", "", ErrInvalidRequest},
+		{"dot", "https://cgl.example.org/api/v1/user/", ".", "", ErrInvalidRequest},
+		{"path traversal", "https://cgl.example.org/api/v1/user/", "../../admin", "", ErrInvalidRequest},
+		{"query injection", "https://cgl.example.org/api/v1/user?NHS_number=", "9999999468&admin=true", "", ErrInvalidRequest},
+		{"too short", "https://cgl.example.org/api/v1/user?NHS_number=", "999999946", "", ErrInvalidRequest},
+		{"not digits", "https://cgl.example.org/api/v1/user?NHS_number=", "99999994a8", "", ErrInvalidRequest},
+		{"empty", "https://cgl.example.org/api/v1/user?NHS_number=", "", "", ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		pdq := PDQQuery{Server_URL: tt.server_url, NHS_ID: tt.nhs_id}
+		got, err := pdq.getCGLURL()
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected err %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: getCGLURL = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCGLTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	start := time.Now()
+	err := New_Transaction(&PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_CGL, Server_URL: srv.URL + "/api/v1/user/", NHS_ID: "9999999468", REG_OID: "2.16.840.1.113883.2.1.3.31.2.1.1", Timeout: 1})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("request took %v, want the 1 second Timeout", elapsed)
+	}
+}
+
+func TestPDQmNextLinks(t *testing.T) {
+	var other int32
+	otherSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&other, 1)
+		w.Write([]byte(testPDQmBundle))
+	}))
+	defer otherSrv.Close()
+	var next string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(testPDQmBundle))
+			return
+		}
+		w.Write([]byte(`{"resourceType":"Bundle","type":"searchset","link":[{"relation":"next","url":"` + next + `"}],"entry":[{"resource":{"resourceType":"Patient","id":"1","identifier":[{"system":"urn:oid:2.16.840.1.113883.2.1.4.1","value":"9999999468"}]}}]}`))
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name    string
+		next    string
+		entries int
+	}{
+		{"same server", srv.URL + "/Patient?page=2", 2},
+		{"other host", otherSrv.URL + "/Patient?page=2", 1},
+		{"other scheme", strings.Replace(srv.URL, "http://", "https://", 1) + "/Patient?page=2", 1},
+	}
+	for _, tt := range tests {
+		next = tt.next
+		pdq := PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQM, Server_URL: srv.URL + "/Patient", NHS_ID: "9999999468", REG_OID: "2.16.840.1.113883.2.1.3.31.2.1.1", Timeout: 1}
+		if err := New_Transaction(&pdq); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := len(pdq.PIXmResponse.Entry); got != tt.entries {
+			t.Errorf("%s: %v entries, want %v", tt.name, got, tt.entries)
+		}
+	}
+	if atomic.LoadInt32(&other) != 0 {
+		t.Error("next link to another host was followed")
+	}
+}
+
+func TestPDQmTimeoutAppliesToAllPages(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(400 * time.Millisecond)
+		w.Write([]byte(`{"resourceType":"Bundle","type":"searchset","link":[{"relation":"next","url":"` + srv.URL + `/Patient?page=next"}],"entry":[{"resource":{"resourceType":"Patient","id":"1"}}]}`))
+	}))
+	defer srv.Close()
+	start := time.Now()
+	err := New_Transaction(&PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQM, Server_URL: srv.URL + "/Patient", NHS_ID: "9999999468", REG_OID: testREGOID, Timeout: 1})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("paged search took %v, want the 1 second Timeout", elapsed)
+	}
+}
+
+func TestPIXmParametersLocalIdentifiers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"resourceType":"Parameters","parameter":[` +
+			`{"name":"targetIdentifier","valueIdentifier":{"system":"urn:oid:` + testREGOID + `","value":"REG.1"}},` +
+			`{"name":"targetIdentifier","valueIdentifier":{"system":"urn:oid:` + testMRNOID + `","value":"MRN123"}},` +
+			`{"name":"targetIdentifier","valueIdentifier":{"system":"urn:oid:1.2.3.9","value":"LAB9"}}]}`))
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name       string
+		mrnoid     string
+		wantPID    string
+		wantPIDOID string
+	}{
+		{"mrn oid not set returns the first local id", "", "MRN123", testMRNOID},
+		{"mrn oid set returns the mrn domain id", "1.2.3.9", "LAB9", "1.2.3.9"},
+	}
+	for _, tt := range tests {
+		pdq := PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PIXM_ITI83, Server_URL: srv.URL, NHS_ID: "9999999468", REG_OID: testREGOID, MRN_OID: tt.mrnoid}
+		if err := New_Transaction(&pdq); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		pat := (*pdq.Patients)[0]
+		if pat.REGID != "REG.1" || pat.NHSID != "9999999468" {
+			t.Errorf("%s: REGID = %q NHSID = %q, want REG.1 and 9999999468", tt.name, pat.REGID, pat.NHSID)
+		}
+		if pat.PID != tt.wantPID || pat.PIDOID != tt.wantPIDOID {
+			t.Errorf("%s: PID = %q %q, want %q %q", tt.name, pat.PID, pat.PIDOID, tt.wantPID, tt.wantPIDOID)
+		}
+	}
+}
+
+func TestEscapeXML(t *testing.T) {
+	tests := []struct {
+		val  string
+		want string
+	}{
+		{"9999999468", "9999999468"},
+		{"O'Brien", "O&#39;Brien"},
+		{`"quoted"`, "&#34;quoted&#34;"},
+		{"<evil/>", "&lt;evil/&gt;"},
+		{"a & b", "a &amp; b"},
+		{"]]>", "]]&gt;"},
+		{"&amp;", "&amp;amp;"},
+		{"line\nbreak", "line&#xA;break"},
+	}
+	for _, tt := range tests {
+		if got := escapeXML(tt.val); got != tt.want {
+			t.Errorf("escapeXML(%q) = %q, want %q", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeHL7v2(t *testing.T) {
+	tests := []struct {
+		val  string
+		want string
+	}{
+		{"9999999468", "9999999468"},
+		{"A|B", `A\F\B`},
+		{"A^B", `A\S\B`},
+		{"A&B", `A\T\B`},
+		{"A~B", `A\R\B`},
+		{`A\B`, `A\E\B`},
+		{"A\rMSH|^~\\&|EVIL", `A MSH\F\\S\\R\\E\\T\\F\EVIL`},
+		{"A\nB", "A B"},
+	}
+	for _, tt := range tests {
+		if got := escapeHL7v2(tt.val); got != tt.want {
+			t.Errorf("escapeHL7v2(%q) = %q, want %q", tt.val, got, tt.want)
+		}
+	}
+}
+
+// TestSOAPTemplatesEscapeHostileValues renders each SOAP request template with query values containing xml markup and checks the request is well formed xml,
+// no injected element is present and each value is returned unchanged when the xml is parsed
+func TestSOAPTemplatesEscapeHostileValues(t *testing.T) {
+	hostile := map[string]string{
+		"Used_PID":     `9999'/><evil a="1"/><id extension='`,
+		"Used_PID_OID": `1.2.3"><evil/>`,
+		"GivenName":    `<given>Nhs</given><evil/>`,
+		"FamilyName":   `O'Brien & Sons ]]> <![CDATA[`,
+		"Zip":          `PR1 1PR</postalCode><evil/>`,
+		"Query_ID":     `q'1<evil/>`,
+	}
+	for _, name := range []string{tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, pdqv3ContinuationTemplate, tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3, pixv3FeedTemplate, tukcnst.PDQ_SERVER_TYPE_IHE_XCPD} {
+		pdq := PDQQuery{
+			Server_Mode:        name,
+			Server_URL:         "https://pdq.example.org/pdq?a=1&b=<2>",
+			Used_PID:           hostile["Used_PID"],
+			Used_PID_OID:       hostile["Used_PID_OID"],
+			NHS_ID:             hostile["Used_PID"],
+			NHS_OID:            "2.16.840.1.113883.2.1.4.1",
+			GivenName:          hostile["GivenName"],
+			FamilyName:         hostile["FamilyName"],
+			Zip:                hostile["Zip"],
+			Street:             hostile["FamilyName"],
+			Query_ID:           hostile["Query_ID"],
+			Query_ID_Root:      hostile["Used_PID_OID"],
+			Message_ID:         "00000000-0000-0000-0000-000000000001",
+			Target_Systems:     []string{hostile["Used_PID_OID"]},
+			Home_Community_OID: "1.2.3.7",
+			Community_OID:      "1.2.3.8",
+			Feed_Action:        tukcnst.PIX_FEED_ACTION_ADD,
+		}
+		pdq.setDevices()
+		if err := pdq.setTemplateRequest(name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		values := map[string]bool{}
+		dec := xml.NewDecoder(bytes.NewReader(pdq.Request))
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: request is not well formed xml - %v\n%s", name, err, pdq.Request)
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				if tok.Name.Local == "evil" {
+					t.Errorf("%s: request contains an injected element\n%s", name, pdq.Request)
+				}
+				for _, attr := range tok.Attr {
+					values[attr.Value] = true
+				}
+			case xml.CharData:
+				values[string(tok)] = true
+			}
+		}
+		for field, val := range hostile {
+			if bytes.Contains(pdq.Request, []byte(val)) && val != "" {
+				t.Errorf("%s: %s is not escaped in the request", name, field)
+			}
+		}
+		switch name {
+		case pdqv3ContinuationTemplate:
+			if !values[hostile["Query_ID"]] || !values[hostile["Used_PID_OID"]] {
+				t.Errorf("%s: query id is not returned unchanged when the request is parsed", name)
+			}
+		default:
+			if !values[hostile["Used_PID"]] || !values[hostile["Used_PID_OID"]] {
+				t.Errorf("%s: patient id is not returned unchanged when the request is parsed", name)
+			}
+		}
+		if name == tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3 || name == pixv3FeedTemplate || name == tukcnst.PDQ_SERVER_TYPE_IHE_XCPD {
+			if !values[hostile["GivenName"]] || !values[hostile["FamilyName"]] {
+				t.Errorf("%s: names are not returned unchanged when the request is parsed", name)
+			}
+		}
+	}
+}
+
+func TestPIXmBundlePatients(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"resourceType":"Bundle","type":"searchset","total":2,"entry":[` +
+			`{"resource":{"resourceType":"Patient","id":"1","identifier":[{"system":"urn:oid:` + testNHSOID + `","value":"9999999468"},{"system":"urn:oid:` + testREGOID + `","value":"REG.1"}],` +
+			`"name":[{"family":"Testpatient","given":["Nhs","A"]}],"gender":"female","birthDate":"1962-04-04","address":[{"line":["1 Preston Road"],"city":"Preston","postalCode":"PR1 1PR"}]}},` +
+			`{"resource":{"resourceType":"Patient","id":"2","identifier":[{"system":"urn:oid:` + testNHSOID + `","value":"9999999484"},{"system":"urn:oid:` + testREGOID + `","value":"REG.2"}],` +
+			`"name":[{"family":"O'Brien","given":["Mary"]}],"gender":"male","birthDate":"1970-01-01","address":[{"postalCode":"PR2 2PR"}]}}]}`))
+	}))
+	defer srv.Close()
+	pdq := PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PIXM, Server_URL: srv.URL, REG_ID: "REG.1", REG_OID: testREGOID, Timeout: 1}
+	if err := New_Transaction(&pdq); err != nil {
+		t.Fatal(err)
+	}
+	if pdq.Patients == nil || pdq.Count != len(*pdq.Patients) || pdq.Count != 2 {
+		t.Fatalf("Count = %v Patients = %+v, want 2 patients", pdq.Count, pdq.Patients)
+	}
+	if pdq.NHS_ID != "9999999468" || pdq.REG_ID != "REG.1" || pdq.GivenName != "Nhs A" || pdq.FamilyName != "Testpatient" || pdq.BirthDate != "19620404" || pdq.Gender != "female" || pdq.Zip != "PR1 1PR" {
+		t.Errorf("query = %s %s %s %s %s %s %s, want the first patient", pdq.NHS_ID, pdq.REG_ID, pdq.GivenName, pdq.FamilyName, pdq.BirthDate, pdq.Gender, pdq.Zip)
+	}
+	if pat := (*pdq.Patients)[1]; pat.NHSID != "9999999484" || pat.REGID != "REG.2" || pat.FamilyName != "O'Brien" || pat.BirthDate != "19700101" || pat.Zip != "PR2 2PR" {
+		t.Errorf("second patient = %+v, want 9999999484 REG.2 O'Brien", pat)
+	}
+}
+
+func TestPDQv3Demographics(t *testing.T) {
+	tests := []struct {
+		name       string
+		gender     string
+		telecom    string
+		wantGender string
+		wantPhone  string
+		wantEmail  string
+	}{
+		{"female", `code="F"`, `<telecom value="mailto:nhs.testpatient@example.org"/><telecom use="HP" value="tel:01772 123456"/><telecom use="MC" value="tel:07700 900000"/>`, "female", "01772 123456", "nhs.testpatient@example.org"},
+		{"male", `code="M"`, `<telecom use="MC" value="tel:07700 900000"/>`, "male", "07700 900000", ""},
+		{"undifferentiated", `code="UN"`, `<telecom value="mailto:a@example.org"/><telecom value="mailto:b@example.org"/>`, "other", "", "a@example.org"},
+		{"no gender code", `nullFlavor="UNK"`, `<telecom value="fax:01772 000000"/>`, "unknown", "", ""},
+	}
+	for _, tt := range tests {
+		rsp := strings.ReplaceAll(testPDQv3Response, "{{REMAINING}}", "0")
+		rsp = strings.Replace(rsp, `code="F"`, tt.gender, 1)
+		rsp = strings.Replace(rsp, `<telecom value="mailto:nhs.testpatient@example.org"/><telecom use="HP" value="tel:01772 123456"/><telecom use="MC" value="tel:07700 900000"/>`, tt.telecom, 1)
+		srv, _ := newSOAPStandIn(t, rsp)
+		pdq := PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3, Server_URL: srv.URL, NHS_ID: "9999999468", REG_OID: testREGOID, Timeout: 1}
+		if err := New_Transaction(&pdq); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		pat := (*pdq.Patients)[0]
+		if pat.Gender != tt.wantGender || pat.Phone != tt.wantPhone || pat.Email != tt.wantEmail {
+			t.Errorf("%s: Gender = %q Phone = %q Email = %q, want %q %q %q", tt.name, pat.Gender, pat.Phone, pat.Email, tt.wantGender, tt.wantPhone, tt.wantEmail)
+		}
+		want := TUKPatient{NHSID: "9999999468", REGID: "REG.1", GivenName: "Nhs A", FamilyName: "Testpatient", BirthDate: "19620404",
+			Street: "1 Preston Road", Town: "Fulwood", City: "Preston", State: "Lancashire", Zip: "PR1 1PR", Country: "GBR", MaritalStatus: "M", MultipleBirth: true}
+		if pat.NHSID != want.NHSID || pat.REGID != want.REGID || pat.GivenName != want.GivenName || pat.FamilyName != want.FamilyName || pat.BirthDate != want.BirthDate ||
+			pat.Street != want.Street || pat.Town != want.Town || pat.City != want.City || pat.State != want.State || pat.Zip != want.Zip || pat.Country != want.Country ||
+			pat.MaritalStatus != want.MaritalStatus || pat.Deceased || !pat.MultipleBirth {
+			t.Errorf("%s: patient = %+v, want %+v", tt.name, pat, want)
+		}
+	}
+}
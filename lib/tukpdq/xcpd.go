@@ -0,0 +1,109 @@
+package tukpdq
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+// XCPDGateway is an XCPD responding gateway. Community_OID is the home community id of the responding gateway.
+// StatusCode, Count and Error are set from the gateway response when an xcpd query is performed
+type XCPDGateway struct {
+	Community_OID string `json:",omitempty"`
+	URL           string `json:",omitempty"`
+	StatusCode    int    `json:",omitempty"`
+	Count         int
+	Error         string `json:",omitempty"`
+}
+
+// newXCPDQuery performs an IHE ITI-55 Cross Gateway Patient Discovery query against each of the XCPD_Gateways concurrently.
+// If XCPD_Gateways is not set, the gateways are taken from the Server_URL, which is a comma separated list of gateway urls, each optionally prefixed with the gateway home community oid and a | separator
+//
+//	eg 2.16.840.1.113883.2.1.3.31.2.1.1|https://gateway.region1.nhs.uk/xcpd,2.16.840.1.113883.2.1.3.32.2.1.1|https://gateway.region2.nhs.uk/xcpd
+//
+// Each matched patient is tagged with the Community of the responding gateway. An error is only returned if every gateway fails
+func (i *PDQQuery) newXCPDQuery() error {
+	if i.Home_Community_OID == "" {
+		return newInvalidRequestError("home community oid is not set")
+	}
+	if len(i.XCPD_Gateways) == 0 {
+		i.XCPD_Gateways = getXCPDGateways(i.Server_URL)
+	}
+	queries := make([]PDQQuery, len(i.XCPD_Gateways))
+	errs := make([]error, len(i.XCPD_Gateways))
+	var wg sync.WaitGroup
+	for n, gw := range i.XCPD_Gateways {
+		queries[n] = *i
+		queries[n].Server_URL = gw.URL
+		queries[n].Community_OID = gw.Community_OID
+		queries[n].Patients = nil
+		queries[n].Count = 0
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			if errs[n] = queries[n].newIHESOAPTemplateRequest(tukcnst.PDQ_SERVER_TYPE_IHE_XCPD, tukcnst.SOAP_ACTION_XCPD_Request); errs[n] == nil {
+				errs[n] = queries[n].setPDQv3Patients()
+			}
+		}(n)
+	}
+	wg.Wait()
+	var err error
+	responded := false
+	for n := range queries {
+		gw := &i.XCPD_Gateways[n]
+		gw.StatusCode = queries[n].StatusCode
+		if errs[n] != nil {
+			gw.Error = errs[n].Error()
+			log.Printf("XCPD gateway %s %s failed - %s", gw.Community_OID, gw.URL, gw.Error)
+			if err == nil {
+				err = errs[n]
+			}
+			continue
+		}
+		if !responded {
+			responded = true
+			i.Request = queries[n].Request
+			i.Message_ID = queries[n].Message_ID
+			i.Query_ID = queries[n].Query_ID
+			i.Response = queries[n].Response
+			i.StatusCode = queries[n].StatusCode
+			i.PDQv3Response = queries[n].PDQv3Response
+		}
+		if queries[n].Patients == nil {
+			continue
+		}
+		for p, pat := range *queries[n].Patients {
+			pat.Community = gw.Community_OID
+			if root := queries[n].PDQv3Response.Body.PRPAIN201306UV02.ControlActProcess.Subject[p].RegistrationEvent.Custodian.AssignedEntity.ID.Root; root != "" {
+				pat.Community = root
+			}
+			i.addPatient(pat)
+			gw.Count++
+		}
+		l(fmt.Sprintf("XCPD gateway %s returned %v patients", gw.Community_OID, gw.Count), true)
+	}
+	if !responded {
+		return err
+	}
+	return nil
+}
+
+// getXCPDGateways returns the XCPDGateway for each gateway in a comma separated list of [community oid|]url
+func getXCPDGateways(gateways string) []XCPDGateway {
+	gws := []XCPDGateway{}
+	for _, gateway := range strings.Split(gateways, ",") {
+		if gateway = strings.TrimSpace(gateway); gateway == "" {
+			continue
+		}
+		gw := XCPDGateway{URL: gateway}
+		if oid, url, found := strings.Cut(gateway, "|"); found {
+			gw.Community_OID = strings.TrimPrefix(strings.TrimSpace(oid), tukcnst.URN_OID_PREFIX)
+			gw.URL = strings.TrimSpace(url)
+		}
+		gws = append(gws, gw)
+	}
+	return gws
+}
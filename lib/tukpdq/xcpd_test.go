@@ -0,0 +1,54 @@
+package tukpdq
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipthomas/tukcnst"
+)
+
+func TestXCPDGatewayFailures(t *testing.T) {
+	ok, _ := newSOAPStandIn(t, strings.ReplaceAll(testPDQv3Response, "{{REMAINING}}", "0"))
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><S:Body><S:Fault><S:Code><S:Value>S:Receiver</S:Value></S:Code><S:Reason><S:Text>Gateway unavailable</S:Text></S:Reason></S:Fault></S:Body></S:Envelope>`))
+	}))
+	defer failed.Close()
+	tests := []struct {
+		name      string
+		gateways  string
+		wantErr   bool
+		wantCount int
+	}{
+		{"one gateway fails", "1.1.1|" + failed.URL + ",2.2.2|" + ok.URL, false, 1},
+		{"every gateway fails", "1.1.1|" + failed.URL + ",2.2.2|" + failed.URL, true, 0},
+	}
+	for _, tt := range tests {
+		pdq := PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_XCPD, Server_URL: tt.gateways, NHS_ID: "9999999468", REG_OID: testREGOID, Home_Community_OID: "9.9.9", Timeout: 1}
+		err := New_Transaction(&pdq)
+		if len(pdq.XCPD_Gateways) != 2 || pdq.XCPD_Gateways[0].Error == "" || pdq.XCPD_Gateways[0].Count != 0 {
+			t.Errorf("%s: XCPD_Gateways = %+v, want the failed gateway error", tt.name, pdq.XCPD_Gateways)
+		}
+		if tt.wantErr {
+			var faulterr *SOAPFaultError
+			if !errors.As(err, &faulterr) || faulterr.Reason != "Gateway unavailable" {
+				t.Errorf("%s: err = %v, want the gateway SOAPFaultError", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: err = %v, want nil", tt.name, err)
+			continue
+		}
+		if pdq.Count != tt.wantCount || pdq.XCPD_Gateways[1].Count != tt.wantCount || pdq.XCPD_Gateways[1].Error != "" {
+			t.Errorf("%s: Count = %v XCPD_Gateways = %+v, want %v patients from the responding gateway", tt.name, pdq.Count, pdq.XCPD_Gateways, tt.wantCount)
+			continue
+		}
+		if pat := (*pdq.Patients)[0]; pat.Community != "2.2.2" || pat.NHSID != "9999999468" {
+			t.Errorf("%s: patient = %+v, want 9999999468 from community 2.2.2", tt.name, pat)
+		}
+	}
+}
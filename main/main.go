@@ -1,19 +1,127 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/ipthomas/tukcnst"
 	"github.com/ipthomas/tukpdq"
+	"github.com/ipthomas/tukutil"
 )
 
+const (
+	ERROR_CODE_INVALID_REQUEST  = "INVALID_REQUEST"
+	ERROR_CODE_NOT_FOUND        = "NOT_FOUND"
+	ERROR_CODE_ACK_REJECTED     = "ACK_REJECTED"
+	ERROR_CODE_UPSTREAM_ERROR   = "UPSTREAM_ERROR"
+	ERROR_CODE_UPSTREAM_TIMEOUT = "UPSTREAM_TIMEOUT"
+	ERROR_CODE_SOAP_FAULT       = "SOAP_FAULT"
+	HEADER_CORRELATION_ID       = "X-Correlation-Id"
+	HEADER_DEBUG_TOKEN          = "X-Debug-Token"
+	LAMBDA_DEADLINE_MARGIN      = 500 * time.Millisecond
+)
+
+// ErrorResponse is the json body returned when a pdq fails and is also used to report warnings from any additional (eg CGL) queries.
+// Ack is set to the acknowledgement code and details of a rejected HL7 acknowledgement and Fault to the code, reason and detail of a SOAP Fault. Sources is set when additional backends were queried
+type ErrorResponse struct {
+	Code          string                 `json:"code"`
+	Message       string                 `json:"message"`
+	Backend       string                 `json:"backend"`
+	CorrelationID string                 `json:"correlationid"`
+	Ack           *tukpdq.AckError       `json:"ack,omitempty"`
+	Fault         *tukpdq.SOAPFaultError `json:"fault,omitempty"`
+	Sources       []PDQSourceStatus      `json:"sources,omitempty"`
+}
+
+// PDQResponse is the public json body returned for a successful pdq. It never contains credentials and only includes the raw pdq server request and response when an authorised debug request is made
+type PDQResponse struct {
+	Server_Mode        string                  `json:",omitempty"`
+	NHS_ID             string                  `json:",omitempty"`
+	NHS_OID            string                  `json:",omitempty"`
+	MRN_ID             string                  `json:",omitempty"`
+	MRN_OID            string                  `json:",omitempty"`
+	REG_ID             string                  `json:",omitempty"`
+	REG_OID            string                  `json:",omitempty"`
+	GivenName          string                  `json:"givenname"`
+	FamilyName         string                  `json:"familyname"`
+	BirthDate          string                  `json:"birthdate"`
+	Gender             string                  `json:"gender"`
+	Zip                string                  `json:"zip"`
+	Street             string                  `json:"street"`
+	Town               string                  `json:"town"`
+	City               string                  `json:"city"`
+	Country            string                  `json:"country"`
+	Phone              string                  `json:"phone"`
+	Email              string                  `json:"email"`
+	Used_PID           string                  `json:",omitempty"`
+	Used_PID_OID       string                  `json:",omitempty"`
+	Message_ID         string                  `json:",omitempty"`
+	Continuation_Token string                  `json:",omitempty"`
+	Remaining          int                     `json:",omitempty"`
+	StatusCode         int                     `json:",omitempty"`
+	Count              int                     `json:",omitempty"`
+	Cached             bool                    `json:",omitempty"`
+	Cache_Age          int64                   `json:",omitempty"`
+	Cache_Stale        bool                    `json:",omitempty"`
+	Patients           *[]tukpdq.TUKPatient    `json:",omitempty"`
+	XCPD_Gateways      []tukpdq.XCPDGateway    `json:",omitempty"`
+	CGLUserResponse    *tukpdq.CGLUserResponse `json:",omitempty"`
+	Merged_Patient     *tukpdq.TUKPatient      `json:",omitempty"`
+	Sources            []PDQSourceStatus       `json:"sources,omitempty"`
+	Discrepancies      []tukpdq.Discrepancy    `json:"discrepancies,omitempty"`
+	Warnings           []ErrorResponse         `json:"warnings,omitempty"`
+	Debug              *PDQDebug               `json:"debug,omitempty"`
+}
+
+// PDQSourceStatus is the outcome of the query against each backend when additional backends are included using the query param _include
+type PDQSourceStatus struct {
+	Backend    string `json:"backend"`
+	Status     int    `json:"status"`
+	Code       string `json:"code,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Count      int    `json:"count"`
+	Cached     bool   `json:"cached,omitempty"`
+	DurationMS int64  `json:"durationms"`
+}
+
+// PIXFeedResponse is the public json body returned for a successful PIX identity feed
+type PIXFeedResponse struct {
+	Server_Mode  string    `json:",omitempty"`
+	Feed_Action  string    `json:",omitempty"`
+	Used_PID     string    `json:",omitempty"`
+	Used_PID_OID string    `json:",omitempty"`
+	Ack_Code     string    `json:",omitempty"`
+	StatusCode   int       `json:",omitempty"`
+	Debug        *PDQDebug `json:"debug,omitempty"`
+}
+type PDQDebug struct {
+	Server_URL    string                `json:",omitempty"`
+	Request       string                `json:",omitempty"`
+	Response      string                `json:",omitempty"`
+	PDQv3Response *tukpdq.PDQv3Response `json:",omitempty"`
+	PIXv3Response *tukpdq.PIXv3Response `json:",omitempty"`
+	PIXmResponse  *tukpdq.PIXmResponse  `json:",omitempty"`
+	Cache         *tukpdq.CacheStats    `json:",omitempty"`
+}
+
 func main() {
+	tukpdq.SetPatientCache(newPatientCache())
+	setDeviceProfiles()
+	loadTemplates()
 	lambda.Start(Handle_Request)
 }
 
@@ -26,6 +134,14 @@ func main() {
 //
 // or 	PIXm server wse  - http://spirit-test-01.tianispirit.co.uk:8081/SpiritPIXFhir/r4/Patient
 //
+// or 	PDQm server wse  - http://spirit-test-01.tianispirit.co.uk:8081/SpiritPIXFhir/r4/Patient
+//
+// or 	PDQv2 MLLP server - mllp://spirit-test-01.tianispirit.co.uk:3600
+//
+// or 	PIXv2 MLLP server - mllp://spirit-test-01.tianispirit.co.uk:3700
+//
+// or 	XCPD gateways    - 2.16.840.1.113883.2.1.3.31.2.1.1|https://gateway.region1.nhs.uk/xcpd,2.16.840.1.113883.2.1.3.32.2.1.1|https://gateway.region2.nhs.uk/xcpd
+//
 // Set AWS Env PDQ_SERVER_TYPE to specify the PDQ server type.
 //
 //	Valid types are
@@ -35,26 +151,86 @@ func main() {
 //
 // or 	PIXm  FHIR server - pixm
 //
+// or 	PIXm  FHIR server - ihepix (IHE ITI-83 $ihe-pix operation using the PIXm server wse)
+//
+// or 	PDQm  FHIR server - pdqm
+//
+// or 	PDQv2 MLLP server - pdqv2 (IHE ITI-21 HL7 v2 QBP^Q22 query)
+//
+// or 	PIXv2 MLLP server - pixv2 (IHE ITI-9 HL7 v2 QBP^Q23 query)
+//
+// or 	XCPD  SOAP gateways - xcpd (IHE ITI-55 cross gateway patient discovery. Set AWS Env Home_Community_OID to the home community oid)
+//
 // or 	CGL   HTTP server - cgl
 //
 // Set AWS Env Reg_OID to the regional oid
 //
-// A PDQ against any of the 3 IHE PDQ server types can also include the results of a query against the CGL service if the CGL_API_KEY and CGL_SERVER_URL are set
+// A PDQ against any of the IHE PDQ server types can also include the results of a query against the CGL service if the CGL_API_KEY and CGL_SERVER_URL are set
 // To perform just a query against the CGL service, set PDQ_SERVER_TYPE=cgl
-func Handle_Request(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+//
+// Failed queries return a json ErrorResponse with a http status code mapped from the failure
+//
+//	400 - invalid request, no usable id and oid or server url
+//	404 - patient not found
+//	502 - pdq server error (ACK_REJECTED, SOAP_FAULT or UPSTREAM_ERROR)
+//	504 - pdq server timeout
+//
+// A PDQv3, PDQv2, PDQm or XCPD query can search by demographics instead of by id using any of the query params familyname, givenname, dob, gender and zip.
+//
+// An ihepix or pixv2 query can be restricted to one or more target domains by setting query param targetsystem to a comma separated list of domain oids.
+//
+// A PDQv3 query can limit the number of matches returned using the query param quantity. If more matches remain, the response includes a Continuation_Token. For PDQm, quantity sets the search page size.
+// The next matches are returned by sending the token as query param continuation, and the query is cancelled by also setting query param cancel=true
+//
+// Additional backends can be queried by setting query param _include to a comma separated list of server types, eg _include=pixm,pixv3,cgl.
+// The included backends are queried concurrently with the primary pdq, each with its own timeout set in AWS Env PDQ_BACKEND_TIMEOUTS (eg pdqv3=5,pixm=3,cgl=2, default 5 secs).
+// The response includes Merged_Patient, the patient values merged from all the backends that found the patient, and a sources status block for each backend.
+// When both an IHE backend and CGL return the patient, the IHE name, birth date, sex and postcode are compared with the CGL basic details and any differences are returned in discrepancies with a severity of high, medium or low.
+// An included query that fails is also returned as a warning alongside the primary pdq result. If the primary pdq fails or finds no patient, the response is built from the included backends that found the patient,
+// with the primary failure returned as a warning, and a deferred CGL query uses the NHS ID they found. The primary pdq error, with the sources status block, is only returned if no backend found the patient.
+//
+// Set AWS Env PATIENT_CACHE=true (or query param cache=true) to cache pdq server responses by server type, server url and patient id and oid. Entries expire after AWS Env PATIENT_CACHE_TTL seconds (default 900)
+// and the least recently used entry is removed once AWS Env PATIENT_CACHE_MAX_ENTRIES (default 1000) is reached. A successful POST removes the cached responses for the patient.
+// The cache is held in memory unless AWS Env PATIENT_CACHE_STORE is set to file or dynamodb, which survive cold starts. File and dynamodb entries are encrypted when AWS Env PATIENT_CACHE_KEY is set.
+// A response served from the cache returns the same patient values as a live query, with Cached set to true and Cache_Age set to the age of the cache entry in seconds.
+// Not found responses are cached for AWS Env PATIENT_CACHE_NOT_FOUND_TTL seconds (default 60, -1 disables). If AWS Env PATIENT_CACHE_STALE_TTL is set, an entry that expired less than that many seconds ago
+// is returned, with Cache_Stale set to true, and refreshed from the pdq server. A Lambda is frozen between invocations, so the refresh completes, within the Lambda deadline, before the handler returns.
+//
+// A POST request registers (query param action=add, the default) or updates (action=revise) the json TUKPatient in the request body with a pixv3 (IHE ITI-44) or pixm (IHE ITI-104) server and returns the acknowledgement code.
+//
+// The pdq server requests are cancelled 500ms before the Lambda deadline, so a 504 timeout response is returned rather than the Lambda being stopped mid request.
+//
+// Set AWS Env PDQ_DEBUG_TOKEN to allow the raw pdq server request and response to be returned. Requests must include the query param debug=true and the X-Debug-Token header set to the PDQ_DEBUG_TOKEN value
+func Handle_Request(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	correlationid := req.RequestContext.RequestID
+	if correlationid == "" {
+		correlationid = tukutil.NewUuid()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-LAMBDA_DEADLINE_MARGIN))
+		defer cancel()
+	}
+	defer tukpdq.WaitForCacheRefreshes(ctx)
 	patcache, _ := strconv.ParseBool(os.Getenv(tukcnst.ENV_PATIENT_CACHE))
 	pdq := tukpdq.PDQQuery{
-		Server_Mode:   os.Getenv(tukcnst.ENV_PDQ_SERVER_TYPE),
-		CGL_X_Api_Key: os.Getenv(tukcnst.ENV_CGL_X_API_KEY),
-		MRN_ID:        req.QueryStringParameters[tukcnst.QUERY_PARAM_MRN_ID],
-		MRN_OID:       req.QueryStringParameters[tukcnst.QUERY_PARAM_MRN_OID],
-		NHS_ID:        req.QueryStringParameters[tukcnst.QUERY_PARAM_NHS_ID],
-		NHS_OID:       os.Getenv(tukcnst.ENV_NHS_OID),
-		REG_ID:        req.QueryStringParameters[tukcnst.QUERY_PARAM_REG_ID],
-		REG_OID:       os.Getenv(tukcnst.ENV_REG_OID),
-		Server_URL:    os.Getenv(tukcnst.ENV_PDQ_SERVER_URL),
-		Cache:         patcache,
-		Timeout:       5,
+		Server_Mode:        os.Getenv(tukcnst.ENV_PDQ_SERVER_TYPE),
+		CGL_X_Api_Key:      os.Getenv(tukcnst.ENV_CGL_X_API_KEY),
+		FamilyName:         req.QueryStringParameters[tukcnst.QUERY_PARAM_FAMILY_NAME],
+		GivenName:          req.QueryStringParameters[tukcnst.QUERY_PARAM_GIVEN_NAME],
+		BirthDate:          req.QueryStringParameters[tukcnst.QUERY_PARAM_BIRTH_DATE],
+		Gender:             req.QueryStringParameters[tukcnst.QUERY_PARAM_GENDER],
+		Zip:                req.QueryStringParameters[tukcnst.QUERY_PARAM_ZIP],
+		MRN_ID:             req.QueryStringParameters[tukcnst.QUERY_PARAM_MRN_ID],
+		MRN_OID:            req.QueryStringParameters[tukcnst.QUERY_PARAM_MRN_OID],
+		NHS_ID:             req.QueryStringParameters[tukcnst.QUERY_PARAM_NHS_ID],
+		NHS_OID:            os.Getenv(tukcnst.ENV_NHS_OID),
+		REG_ID:             req.QueryStringParameters[tukcnst.QUERY_PARAM_REG_ID],
+		REG_OID:            os.Getenv(tukcnst.ENV_REG_OID),
+		Server_URL:         os.Getenv(tukcnst.ENV_PDQ_SERVER_URL),
+		Home_Community_OID: os.Getenv(tukcnst.HOME_COMMUNITY_OID),
+		Cache:              patcache,
+		Timeout:            5,
 	}
 	if req.QueryStringParameters[tukcnst.QUERY_PARAM_NHS_OID] != "" {
 		pdq.NHS_OID = req.QueryStringParameters[tukcnst.QUERY_PARAM_NHS_OID]
@@ -76,34 +252,489 @@ func Handle_Request(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyR
 		pdqcache, _ := strconv.ParseBool(req.QueryStringParameters[tukcnst.QUERY_PARAM_CACHE])
 		pdq.Cache = pdqcache
 	}
+	if req.QueryStringParameters[tukcnst.QUERY_PARAM_TARGET_SYSTEM] != "" {
+		pdq.Target_Systems = strings.Split(req.QueryStringParameters[tukcnst.QUERY_PARAM_TARGET_SYSTEM], ",")
+	}
+	if req.QueryStringParameters[tukcnst.QUERY_PARAM_QUANTITY] != "" {
+		pdq.Initial_Quantity, _ = strconv.Atoi(req.QueryStringParameters[tukcnst.QUERY_PARAM_QUANTITY])
+	}
+	pdq.Continuation_Token = req.QueryStringParameters[tukcnst.QUERY_PARAM_CONTINUATION]
+	pdq.Cancel, _ = strconv.ParseBool(req.QueryStringParameters[tukcnst.QUERY_PARAM_CANCEL])
+	if req.HTTPMethod == http.MethodPost {
+		return newPIXFeedResponse(ctx, req, &pdq, correlationid), nil
+	}
+	timeouts := getBackendTimeouts()
+	if timeout, ok := timeouts[pdq.Server_Mode]; ok {
+		pdq.Timeout = timeout
+	}
+	// the included backends are queried concurrently with the primary pdq. A CGL query needs an NHS ID so is deferred until the primary pdq completes if no NHS ID was provided
+	var includes, deferred []*tukpdq.PDQQuery
+	if pdq.Continuation_Token == "" && !pdq.Cancel {
+		for _, srv := range getIncludes(req, pdq.Server_Mode) {
+			inc := newIncludeQuery(&pdq, srv, timeouts)
+			if inc == nil {
+				continue
+			}
+			if srv == tukcnst.PDQ_SERVER_TYPE_CGL && pdq.NHS_ID == "" {
+				deferred = append(deferred, inc)
+			} else {
+				includes = append(includes, inc)
+			}
+		}
+	}
+	queries := append([]*tukpdq.PDQQuery{&pdq}, includes...)
+	errs, durations := newConcurrentTransactions(ctx, queries)
+	if pdq.Cache {
+		stats := tukpdq.PatientCacheStats()
+		log.Printf("Patient cache entries %v hits %v stale %v misses %v evictions %v", stats.Entries, stats.Hits, stats.Stale, stats.Misses, stats.Evictions)
+	}
+	if errs[0] != nil && len(queries) == 1 && len(deferred) == 0 {
+		return newErrorResponse(&pdq, errs[0], correlationid), nil
+	}
+	if len(deferred) > 0 {
+		nhsid := getFoundNHSID(queries, errs)
+		for _, inc := range deferred {
+			inc.NHS_ID = nhsid
+		}
+		deferrederrs, deferreddurations := newConcurrentTransactions(ctx, deferred)
+		queries = append(queries, deferred...)
+		errs = append(errs, deferrederrs...)
+		durations = append(durations, deferreddurations...)
+	}
+	var warnings []ErrorResponse
+	var sources []PDQSourceStatus
+	merged := tukpdq.TUKPatient{}
+	found := false
+	var ihe *tukpdq.PDQQuery
+	for n, query := range queries {
+		source := PDQSourceStatus{
+			Backend:    query.Server_Mode,
+			Status:     http.StatusOK,
+			Count:      query.Count,
+			Cached:     query.Cached,
+			DurationMS: durations[n].Milliseconds(),
+		}
+		if errs[n] != nil {
+			var warning ErrorResponse
+			source.Status, warning = getErrorResponse(query, errs[n], correlationid)
+			source.Code = warning.Code
+			source.Message = warning.Message
+			log.Printf("%s query failed - %s", query.Server_Mode, warning.Message)
+			warnings = append(warnings, warning)
+		} else if query.Patients != nil && len(*query.Patients) > 0 {
+			found = true
+			mergePatient(&merged, (*query.Patients)[0])
+			switch {
+			case query.Server_Mode == tukcnst.PDQ_SERVER_TYPE_CGL:
+				pdq.CGLUserResponse = query.CGLUserResponse
+			case ihe == nil:
+				ihe = query
+			}
+		}
+		sources = append(sources, source)
+	}
+	// the primary pdq error is only returned if no backend found the patient
+	if errs[0] != nil && !found {
+		status, errRsp := getErrorResponse(&pdq, errs[0], correlationid)
+		errRsp.Sources = sources
+		log.Printf("PDQ failed - Status %v %s %s", status, errRsp.Code, errRsp.Message)
+		return newAPIResponse(status, errRsp, correlationid), nil
+	}
+	rsp := newPDQResponse(&pdq, isDebugRequest(req))
+	rsp.Warnings = warnings
+	if len(queries) > 1 {
+		rsp.Merged_Patient = &merged
+		rsp.Sources = sources
+	}
+	if ihe != nil && pdq.CGLUserResponse != nil {
+		rsp.Discrepancies = tukpdq.ReconcileCGL((*ihe.Patients)[0], ihe.Server_Mode, pdq.CGLUserResponse)
+		log.Printf("%v discrepancies found between %s and CGL demographics", len(rsp.Discrepancies), ihe.Server_Mode)
+	}
+	return newAPIResponse(http.StatusOK, rsp, correlationid), nil
+}
+
+// getIncludes returns the server types in the comma separated query param _include, excluding duplicates and the primary server type
+func getIncludes(req events.APIGatewayProxyRequest, primary string) []string {
+	var includes []string
+	for _, srv := range strings.Split(req.QueryStringParameters[tukcnst.QUERY_PARAM_INCLUDE], ",") {
+		if srv = strings.TrimSpace(srv); srv != "" && srv != primary {
+			if _, found := tukutil.ArrayContains(includes, srv); !found {
+				includes = append(includes, srv)
+			}
+		}
+	}
+	return includes
+}
+
+// newIncludeQuery returns a copy of the primary pdq for the included server type srv, or nil if the included server is not configured
+func newIncludeQuery(pdq *tukpdq.PDQQuery, srv string, timeouts map[string]int64) *tukpdq.PDQQuery {
+	inc := *pdq
+	inc.Server_Mode = srv
+	inc.Server_URL = getPDQServerURL(srv)
+	if timeout, ok := timeouts[srv]; ok {
+		inc.Timeout = timeout
+	}
+	if srv == tukcnst.PDQ_SERVER_TYPE_CGL {
+		if inc.CGL_X_Api_Key == "" {
+			log.Println("CGL_API_KEY is not set. Unable to include CGL query")
+			return nil
+		}
+		inc.NHS_OID = tukcnst.NHS_OID_DEFAULT
+	}
+	return &inc
+}
+
+// getFoundNHSID returns the NHS ID of the first patient found by the queries that did not fail, or "" if none found a patient with an NHS ID
+func getFoundNHSID(queries []*tukpdq.PDQQuery, errs []error) string {
+	for n, query := range queries {
+		if errs[n] != nil || query.Patients == nil {
+			continue
+		}
+		for _, pat := range *query.Patients {
+			if pat.NHSID != "" {
+				return pat.NHSID
+			}
+		}
+	}
+	return ""
+}
 
-	if err := tukpdq.New_Transaction(&pdq); err != nil {
-		log.Println(err.Error())
+// newConcurrentTransactions runs a pdq transaction for each query concurrently and returns the error and duration of each transaction
+func newConcurrentTransactions(ctx context.Context, queries []*tukpdq.PDQQuery) ([]error, []time.Duration) {
+	errs := make([]error, len(queries))
+	durations := make([]time.Duration, len(queries))
+	var wg sync.WaitGroup
+	for n := range queries {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			start := time.Now()
+			errs[n] = tukpdq.New_TransactionWithContext(ctx, queries[n])
+			durations[n] = time.Since(start)
+		}(n)
 	}
+	wg.Wait()
+	return errs, durations
+}
 
-	if pdq.Server_Mode != tukcnst.PDQ_SERVER_TYPE_CGL && pdq.CGL_X_Api_Key != "" && req.QueryStringParameters[tukcnst.QUERY_PARAM_INCLUDE] == tukcnst.PDQ_SERVER_TYPE_CGL {
-		log.Println("Performing additional query against CGL service")
-		cglpdq := tukpdq.PDQQuery{
-			Server_Mode:   tukcnst.PDQ_SERVER_TYPE_CGL,
-			CGL_X_Api_Key: pdq.CGL_X_Api_Key,
-			NHS_ID:        pdq.NHS_ID,
-			NHS_OID:       tukcnst.NHS_OID_DEFAULT,
-			REG_OID:       pdq.REG_OID,
-			Server_URL:    getPDQServerURL(tukcnst.PDQ_SERVER_TYPE_CGL),
+// getBackendTimeouts returns the per server type timeouts, in seconds, set in AWS Env PDQ_BACKEND_TIMEOUTS as a comma separated list of type=seconds. eg pdqv3=5,pixm=3,cgl=2
+func getBackendTimeouts() map[string]int64 {
+	timeouts := make(map[string]int64)
+	for _, timeout := range strings.Split(os.Getenv(tukcnst.ENV_PDQ_BACKEND_TIMEOUTS), ",") {
+		if srv, secs, found := strings.Cut(timeout, "="); found {
+			if t, err := strconv.ParseInt(strings.TrimSpace(secs), 10, 64); err == nil && t > 0 {
+				timeouts[strings.TrimSpace(srv)] = t
+			}
 		}
-		err := tukpdq.New_Transaction(&cglpdq)
+	}
+	return timeouts
+}
+
+// setDeviceProfiles sets the sender and receiver device used in HL7 v3 messages for all server types from AWS Env HL7V3_SENDER_DEVICE_OID, HL7V3_SENDER_DEVICE_NAME, HL7V3_SENDER_ORG_OID, HL7V3_SENDER_ORG_NAME,
+// HL7V3_RECEIVER_DEVICE_OID, HL7V3_RECEIVER_DEVICE_NAME, HL7V3_RECEIVER_ORG_OID and HL7V3_RECEIVER_ORG_NAME, and the per server type devices from AWS Env HL7V3_DEVICE_PROFILES,
+// a json object keyed by server type. eg {"pixv3":{"Sender":{"Device_OID":"1.2.826.0.1.1","Device_Authority_Name":"TUKPDQ"},"Receiver":{"Device_OID":"1.2.826.0.1.2"}}}
+func setDeviceProfiles() {
+	tukpdq.SetDeviceProfile("", tukpdq.DeviceProfile{
+		Sender: tukpdq.HL7v3Device{
+			Device_OID:            os.Getenv(tukcnst.ENV_HL7V3_SENDER_DEVICE_OID),
+			Device_Authority_Name: os.Getenv(tukcnst.ENV_HL7V3_SENDER_DEVICE_NAME),
+			Org_OID:               os.Getenv(tukcnst.ENV_HL7V3_SENDER_ORG_OID),
+			Org_Authority_Name:    os.Getenv(tukcnst.ENV_HL7V3_SENDER_ORG_NAME),
+		},
+		Receiver: tukpdq.HL7v3Device{
+			Device_OID:            os.Getenv(tukcnst.ENV_HL7V3_RECEIVER_DEVICE_OID),
+			Device_Authority_Name: os.Getenv(tukcnst.ENV_HL7V3_RECEIVER_DEVICE_NAME),
+			Org_OID:               os.Getenv(tukcnst.ENV_HL7V3_RECEIVER_ORG_OID),
+			Org_Authority_Name:    os.Getenv(tukcnst.ENV_HL7V3_RECEIVER_ORG_NAME),
+		},
+	})
+	if os.Getenv(tukcnst.ENV_HL7V3_DEVICE_PROFILES) == "" {
+		return
+	}
+	profiles := make(map[string]tukpdq.DeviceProfile)
+	if err := json.Unmarshal([]byte(os.Getenv(tukcnst.ENV_HL7V3_DEVICE_PROFILES)), &profiles); err != nil {
+		log.Printf("Unable to parse %s - %s", tukcnst.ENV_HL7V3_DEVICE_PROFILES, err.Error())
+		return
+	}
+	for srv, profile := range profiles {
+		log.Printf("Setting %s HL7 v3 device profile", srv)
+		tukpdq.SetDeviceProfile(srv, profile)
+	}
+}
+
+// loadTemplates overrides the pdq request templates with the {name}.tmpl files (eg pdqv3.tmpl, pixv3feed.tmpl) in the directory set in AWS Env PDQ_TEMPLATE_DIR
+// and then the template files set in AWS Env PDQ_TEMPLATES as a comma separated list of name=path. eg pdqv3=/opt/templates/vendor_pdqv3.xml,pixv3=/opt/templates/vendor_pixv3.xml
+// A template that is not valid is logged and the built in template is used
+func loadTemplates() {
+	if dir := os.Getenv(tukcnst.ENV_PDQ_TEMPLATE_DIR); dir != "" {
+		loaded, err := tukpdq.LoadTemplates(dir)
 		if err != nil {
-			log.Println(err.Error())
+			log.Printf("Unable to load templates from %s - %s", dir, err.Error())
+		}
+		for _, name := range loaded {
+			log.Printf("Loaded %s template from %s", name, dir)
+		}
+	}
+	for _, tmplt := range strings.Split(os.Getenv(tukcnst.ENV_PDQ_TEMPLATES), ",") {
+		if name, path, found := strings.Cut(tmplt, "="); found {
+			if err := tukpdq.LoadTemplate(strings.TrimSpace(name), strings.TrimSpace(path)); err != nil {
+				log.Printf("Unable to load %s template from %s - %s", strings.TrimSpace(name), strings.TrimSpace(path), err.Error())
+			} else {
+				log.Printf("Loaded %s template from %s", strings.TrimSpace(name), strings.TrimSpace(path))
+			}
+		}
+	}
+}
+
+// newPatientCache returns the patient cache backend set in AWS Env PATIENT_CACHE_STORE (memory, file or dynamodb. Default is memory) with the entry TTL, not found entry TTL and stale TTL (in seconds)
+// set in AWS Env PATIENT_CACHE_TTL, PATIENT_CACHE_NOT_FOUND_TTL and PATIENT_CACHE_STALE_TTL.
+// The memory cache holds at most AWS Env PATIENT_CACHE_MAX_ENTRIES entries. The file cache is held in AWS Env PATIENT_CACHE_DIR (default /tmp/patient_cache) and the dynamodb cache in the table set in AWS Env PATIENT_CACHE_TABLE,
+// using the endpoint in AWS Env PATIENT_CACHE_URL if set. File and dynamodb cache entries are encrypted if AWS Env PATIENT_CACHE_KEY is set to a base64 encoded 16, 24 or 32 byte AES key.
+// If the file or dynamodb cache cannot be created, the memory cache is used
+func newPatientCache() tukpdq.PatientCacheStore {
+	ttl, _ := strconv.Atoi(os.Getenv(tukcnst.ENV_PATIENT_CACHE_TTL))
+	notfoundttl, _ := strconv.Atoi(os.Getenv(tukcnst.ENV_PATIENT_CACHE_NOT_FOUND_TTL))
+	stalettl, _ := strconv.Atoi(os.Getenv(tukcnst.ENV_PATIENT_CACHE_STALE_TTL))
+	policy := tukpdq.CachePolicy{
+		TTL:           time.Duration(ttl) * time.Second,
+		Not_Found_TTL: time.Duration(notfoundttl) * time.Second,
+		Stale_TTL:     time.Duration(stalettl) * time.Second,
+	}
+	maxentries, _ := strconv.Atoi(os.Getenv(tukcnst.ENV_PATIENT_CACHE_MAX_ENTRIES))
+	var store tukpdq.KVStore
+	var err error
+	switch os.Getenv(tukcnst.ENV_PATIENT_CACHE_STORE) {
+	case tukcnst.PATIENT_CACHE_STORE_FILE:
+		dir := os.Getenv(tukcnst.ENV_PATIENT_CACHE_DIR)
+		if dir == "" {
+			dir = "/tmp/patient_cache"
+		}
+		store, err = tukpdq.NewFileStore(dir)
+	case tukcnst.PATIENT_CACHE_STORE_DYNAMODB:
+		store = &tukpdq.DynamoDBStore{
+			URL:    os.Getenv(tukcnst.ENV_PATIENT_CACHE_URL),
+			Region: os.Getenv(tukcnst.ENV_AWS_REGION),
+			Table:  os.Getenv(tukcnst.ENV_PATIENT_CACHE_TABLE),
+		}
+	}
+	if store != nil && err == nil {
+		var key []byte
+		if key, err = base64.StdEncoding.DecodeString(os.Getenv(tukcnst.ENV_PATIENT_CACHE_KEY)); err == nil {
+			var cache *tukpdq.KVCache
+			if cache, err = tukpdq.NewKVCache(store, policy, key); err == nil {
+				log.Printf("Using %s patient cache", os.Getenv(tukcnst.ENV_PATIENT_CACHE_STORE))
+				return cache
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Unable to create %s patient cache, using memory cache - %s", os.Getenv(tukcnst.ENV_PATIENT_CACHE_STORE), err.Error())
+	}
+	return tukpdq.NewPatientCache(policy, maxentries)
+}
+
+// mergePatient sets any empty merged patient values from pat
+func mergePatient(merged *tukpdq.TUKPatient, pat tukpdq.TUKPatient) {
+	setIfEmpty(&merged.PIDOID, pat.PIDOID)
+	setIfEmpty(&merged.PID, pat.PID)
+	setIfEmpty(&merged.REGOID, pat.REGOID)
+	setIfEmpty(&merged.REGID, pat.REGID)
+	setIfEmpty(&merged.NHSOID, pat.NHSOID)
+	setIfEmpty(&merged.NHSID, pat.NHSID)
+	setIfEmpty(&merged.GivenName, pat.GivenName)
+	setIfEmpty(&merged.FamilyName, pat.FamilyName)
+	setIfEmpty(&merged.Gender, pat.Gender)
+	setIfEmpty(&merged.BirthDate, pat.BirthDate)
+	setIfEmpty(&merged.Street, pat.Street)
+	setIfEmpty(&merged.Town, pat.Town)
+	setIfEmpty(&merged.City, pat.City)
+	setIfEmpty(&merged.State, pat.State)
+	setIfEmpty(&merged.Country, pat.Country)
+	setIfEmpty(&merged.Zip, pat.Zip)
+	setIfEmpty(&merged.Phone, pat.Phone)
+	setIfEmpty(&merged.Email, pat.Email)
+	setIfEmpty(&merged.MaritalStatus, pat.MaritalStatus)
+	setIfEmpty(&merged.Community, pat.Community)
+	merged.Deceased = merged.Deceased || pat.Deceased
+	merged.MultipleBirth = merged.MultipleBirth || pat.MultipleBirth
+}
+func newPDQResponse(pdq *tukpdq.PDQQuery, debug bool) PDQResponse {
+	rsp := PDQResponse{
+		Server_Mode:        pdq.Server_Mode,
+		NHS_ID:             pdq.NHS_ID,
+		NHS_OID:            pdq.NHS_OID,
+		MRN_ID:             pdq.MRN_ID,
+		MRN_OID:            pdq.MRN_OID,
+		REG_ID:             pdq.REG_ID,
+		REG_OID:            pdq.REG_OID,
+		GivenName:          pdq.GivenName,
+		FamilyName:         pdq.FamilyName,
+		BirthDate:          pdq.BirthDate,
+		Gender:             pdq.Gender,
+		Zip:                pdq.Zip,
+		Street:             pdq.Street,
+		Town:               pdq.Town,
+		City:               pdq.City,
+		Country:            pdq.Country,
+		Phone:              pdq.Phone,
+		Email:              pdq.Email,
+		Used_PID:           pdq.Used_PID,
+		Used_PID_OID:       pdq.Used_PID_OID,
+		Message_ID:         pdq.Message_ID,
+		Continuation_Token: pdq.Continuation_Token,
+		Remaining:          pdq.Remaining,
+		StatusCode:         pdq.StatusCode,
+		Count:              pdq.Count,
+		Cached:             pdq.Cached,
+		Cache_Age:          pdq.Cache_Age,
+		Cache_Stale:        pdq.Cache_Stale,
+		Patients:           pdq.Patients,
+		XCPD_Gateways:      pdq.XCPD_Gateways,
+		CGLUserResponse:    pdq.CGLUserResponse,
+	}
+	if debug {
+		rsp.Debug = &PDQDebug{
+			Server_URL:    pdq.Server_URL,
+			Request:       string(pdq.Request),
+			Response:      string(pdq.Response),
+			PDQv3Response: pdq.PDQv3Response,
+			PIXv3Response: pdq.PIXv3Response,
+			PIXmResponse:  pdq.PIXmResponse,
+		}
+		if pdq.Cache {
+			stats := tukpdq.PatientCacheStats()
+			rsp.Debug.Cache = &stats
 		}
-		pdq.CGLUserResponse = cglpdq.CGLUserResponse
 	}
-	var b []byte
-	b, _ = json.MarshalIndent(pdq, "", "  ")
-	apiResp := events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Body:       string(b),
+	return rsp
+}
+
+// newPIXFeedResponse sends a PIX identity feed for the json TUKPatient in the request body using the query param action (add or revise, default add) and returns the acknowledgement
+func newPIXFeedResponse(ctx context.Context, req events.APIGatewayProxyRequest, pdq *tukpdq.PDQQuery, correlationid string) *events.APIGatewayProxyResponse {
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		body, _ = base64.StdEncoding.DecodeString(req.Body)
+	}
+	pat := tukpdq.TUKPatient{}
+	if err := json.Unmarshal(body, &pat); err != nil {
+		return newErrorResponse(pdq, fmt.Errorf("%w - request body is not a valid patient - %s", tukpdq.ErrInvalidRequest, err.Error()), correlationid)
+	}
+	setFeedPatient(pdq, pat)
+	pdq.Feed_Action = req.QueryStringParameters[tukcnst.QUERY_PARAM_FEED_ACTION]
+	if err := tukpdq.New_FeedWithContext(ctx, pdq); err != nil {
+		return newErrorResponse(pdq, err, correlationid)
+	}
+	rsp := PIXFeedResponse{
+		Server_Mode:  pdq.Server_Mode,
+		Feed_Action:  pdq.Feed_Action,
+		Used_PID:     pdq.Used_PID,
+		Used_PID_OID: pdq.Used_PID_OID,
+		Ack_Code:     pdq.Ack_Code,
+		StatusCode:   pdq.StatusCode,
+	}
+	if isDebugRequest(req) {
+		rsp.Debug = &PDQDebug{
+			Server_URL: pdq.Server_URL,
+			Request:    string(pdq.Request),
+			Response:   string(pdq.Response),
+		}
+	}
+	return newAPIResponse(http.StatusOK, rsp, correlationid)
+}
+
+// setFeedPatient sets the pdq patient ids and demographics from the non empty TUKPatient values
+func setFeedPatient(pdq *tukpdq.PDQQuery, pat tukpdq.TUKPatient) {
+	setIfNotEmpty(&pdq.MRN_ID, pat.PID)
+	setIfNotEmpty(&pdq.MRN_OID, pat.PIDOID)
+	setIfNotEmpty(&pdq.NHS_ID, pat.NHSID)
+	setIfNotEmpty(&pdq.NHS_OID, pat.NHSOID)
+	setIfNotEmpty(&pdq.REG_ID, pat.REGID)
+	setIfNotEmpty(&pdq.REG_OID, pat.REGOID)
+	setIfNotEmpty(&pdq.GivenName, pat.GivenName)
+	setIfNotEmpty(&pdq.FamilyName, pat.FamilyName)
+	setIfNotEmpty(&pdq.Gender, pat.Gender)
+	setIfNotEmpty(&pdq.BirthDate, pat.BirthDate)
+	setIfNotEmpty(&pdq.Street, pat.Street)
+	setIfNotEmpty(&pdq.Town, pat.Town)
+	setIfNotEmpty(&pdq.City, pat.City)
+	setIfNotEmpty(&pdq.Country, pat.Country)
+	setIfNotEmpty(&pdq.Zip, pat.Zip)
+	setIfNotEmpty(&pdq.Phone, pat.Phone)
+	setIfNotEmpty(&pdq.Email, pat.Email)
+}
+
+func setIfEmpty(field *string, val string) {
+	if *field == "" {
+		*field = val
+	}
+}
+func setIfNotEmpty(field *string, val string) {
+	if val != "" {
+		*field = val
+	}
+}
+
+// isDebugRequest returns true if debug=true is requested and the X-Debug-Token header matches the AWS Env PDQ_DEBUG_TOKEN. Debug is always disabled if PDQ_DEBUG_TOKEN is not set
+func isDebugRequest(req events.APIGatewayProxyRequest) bool {
+	debug, _ := strconv.ParseBool(req.QueryStringParameters[tukcnst.QUERY_PARAM_DEBUG])
+	token := os.Getenv(tukcnst.ENV_PDQ_DEBUG_TOKEN)
+	if !debug || token == "" {
+		return false
+	}
+	reqtoken := req.Headers[HEADER_DEBUG_TOKEN]
+	if reqtoken == "" {
+		reqtoken = req.Headers[strings.ToLower(HEADER_DEBUG_TOKEN)]
+	}
+	return subtle.ConstantTimeCompare([]byte(reqtoken), []byte(token)) == 1
+}
+func newErrorResponse(pdq *tukpdq.PDQQuery, err error, correlationid string) *events.APIGatewayProxyResponse {
+	status, errRsp := getErrorResponse(pdq, err, correlationid)
+	log.Printf("PDQ failed - Status %v %s %s", status, errRsp.Code, errRsp.Message)
+	return newAPIResponse(status, errRsp, correlationid)
+}
+
+// getErrorResponse maps a failed pdq error, using the tukpdq error types, to a http status code and ErrorResponse
+func getErrorResponse(pdq *tukpdq.PDQQuery, err error, correlationid string) (int, ErrorResponse) {
+	errRsp := ErrorResponse{
+		Backend:       pdq.Server_Mode,
+		CorrelationID: correlationid,
+		Message:       err.Error(),
+	}
+	status := http.StatusBadGateway
+	var ackerr *tukpdq.AckError
+	var faulterr *tukpdq.SOAPFaultError
+	var neterr net.Error
+	switch {
+	case errors.Is(err, tukpdq.ErrNotFound):
+		status = http.StatusNotFound
+		errRsp.Code = ERROR_CODE_NOT_FOUND
+	case errors.Is(err, tukpdq.ErrTimeout), errors.Is(err, context.DeadlineExceeded), errors.As(err, &neterr) && neterr.Timeout():
+		status = http.StatusGatewayTimeout
+		errRsp.Code = ERROR_CODE_UPSTREAM_TIMEOUT
+	case errors.Is(err, tukpdq.ErrInvalidRequest):
+		status = http.StatusBadRequest
+		errRsp.Code = ERROR_CODE_INVALID_REQUEST
+	case errors.As(err, &ackerr):
+		errRsp.Code = ERROR_CODE_ACK_REJECTED
+		errRsp.Ack = ackerr
+	case errors.As(err, &faulterr):
+		errRsp.Code = ERROR_CODE_SOAP_FAULT
+		errRsp.Fault = faulterr
+	default:
+		errRsp.Code = ERROR_CODE_UPSTREAM_ERROR
+	}
+	return status, errRsp
+}
+func newAPIResponse(status int, body interface{}, correlationid string) *events.APIGatewayProxyResponse {
+	b, _ := json.MarshalIndent(body, "", "  ")
+	return &events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Headers: map[string]string{
+			tukcnst.CONTENT_TYPE:  tukcnst.APPLICATION_JSON,
+			HEADER_CORRELATION_ID: correlationid,
+		},
+		Body: string(b),
 	}
-	return &apiResp, nil
 }
 func getPDQServerURL(srv string) string {
 	log.Printf("Selecting %s Server URL", srv)
@@ -115,8 +746,16 @@ func getPDQServerURL(srv string) string {
 		srvurl = os.Getenv(tukcnst.ENV_IHE_PDQV3_SERVER_URL)
 	case tukcnst.PDQ_SERVER_TYPE_IHE_PIXV3:
 		srvurl = os.Getenv(tukcnst.ENV_IHE_PIXV3_SERVER_URL)
-	case tukcnst.PDQ_SERVER_TYPE_IHE_PIXM:
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PIXM, tukcnst.PDQ_SERVER_TYPE_IHE_PIXM_ITI83:
 		srvurl = os.Getenv(tukcnst.ENV_IHE_PIXM_SERVER_URL)
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PDQM:
+		srvurl = os.Getenv(tukcnst.ENV_IHE_PDQM_SERVER_URL)
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PDQV2:
+		srvurl = os.Getenv(tukcnst.ENV_IHE_PDQV2_SERVER_URL)
+	case tukcnst.PDQ_SERVER_TYPE_IHE_PIXV2:
+		srvurl = os.Getenv(tukcnst.ENV_IHE_PIXV2_SERVER_URL)
+	case tukcnst.PDQ_SERVER_TYPE_IHE_XCPD:
+		srvurl = os.Getenv(tukcnst.ENV_IHE_XCPD_GATEWAYS)
 	}
 	log.Printf("Selected %s server URL %s", srv, srvurl)
 	return srvurl
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/ipthomas/tukcnst"
+	"github.com/ipthomas/tukpdq"
+)
+
+const pdqv3CancelAck = `<S:Envelope xmlns:S="http://www.w3.org/2003/05/soap-envelope"><S:Header><RelatesTo xmlns="http://www.w3.org/2005/08/addressing">{{RELATESTO}}</RelatesTo></S:Header><S:Body><MCCI_IN000002UV01 xmlns="urn:hl7-org:v3" ITSVersion="XML_1.0"><acknowledgement><typeCode code="AA"/></acknowledgement></MCCI_IN000002UV01></S:Body></S:Envelope>`
+
+var messageIDRegex = regexp.MustCompile(`<MessageID[^>]*>([^<]*)<`)
+
+// newSOAPServer returns a stand-in SOAP pdq server that replies with rsp, with {{RELATESTO}} replaced by the request MessageID, and records the request SOAPAction and body
+func newSOAPServer(t *testing.T, rsp string, soapaction *string, body *string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		*soapaction = r.Header.Get(tukcnst.SOAP_ACTION)
+		*body = string(b)
+		relatesto := ""
+		if m := messageIDRegex.FindStringSubmatch(*body); m != nil {
+			relatesto = m[1]
+		}
+		w.Write([]byte(strings.ReplaceAll(rsp, "{{RELATESTO}}", relatesto)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+const (
+	testPIXmBundle      = `{"resourceType":"Bundle","type":"searchset","total":1,"entry":[{"resource":{"resourceType":"Patient","id":"1","identifier":[{"system":"urn:oid:2.16.840.1.113883.2.1.4.1","value":"9999999468"}],"name":[{"family":"Testpatient","given":["Nhs"]}],"gender":"male","birthDate":"1962-04-04"}}]}`
+	testPIXmEmptyBundle = `{"resourceType":"Bundle","type":"searchset","total":0}`
+	testCGLUser         = `{"data":{"client":{"basicDetails":{"nhsNumber":"9999999468","name":{"family":"Testpatient","given":"Nhs"},"birthDate":"1962-04-04","sexAtBirth":"male"}}}}`
+)
+
+func TestHandleRequestPrimaryFailsIncludeFound(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+	var pixmrsp, cglpath string
+	pixm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(pixmrsp))
+	}))
+	defer pixm.Close()
+	cgl := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cglpath = r.URL.Path
+		w.Write([]byte(testCGLUser))
+	}))
+	defer cgl.Close()
+	t.Setenv(tukcnst.ENV_PDQ_SERVER_TYPE, tukcnst.PDQ_SERVER_TYPE_IHE_PDQM)
+	t.Setenv(tukcnst.ENV_PDQ_SERVER_URL, primary.URL)
+	t.Setenv(tukcnst.ENV_IHE_PIXM_SERVER_URL, pixm.URL)
+	t.Setenv(tukcnst.ENV_CGL_SERVER_URL, cgl.URL+"/api/v1/user/")
+	t.Setenv(tukcnst.ENV_CGL_X_API_KEY, "secret-api-key")
+	t.Setenv(tukcnst.ENV_REG_OID, "2.16.840.1.113883.2.1.3.31.2.1.1")
+	tests := []struct {
+		name        string
+		pixmrsp     string
+		wantStatus  int
+		wantCGLPath string
+	}{
+		{"include finds the patient", testPIXmBundle, http.StatusOK, "/api/v1/user/9999999468"},
+		{"no backend finds the patient", testPIXmEmptyBundle, http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		pixmrsp, cglpath = tt.pixmrsp, ""
+		rsp, err := Handle_Request(context.Background(), events.APIGatewayProxyRequest{
+			QueryStringParameters: map[string]string{
+				tukcnst.QUERY_PARAM_MRN_ID:  "MRN123",
+				tukcnst.QUERY_PARAM_MRN_OID: "1.2.840.114350.1.13.28.1.18.5.999",
+				tukcnst.QUERY_PARAM_INCLUDE: "pixm,cgl",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rsp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: status = %v, want %v - %s", tt.name, rsp.StatusCode, tt.wantStatus, rsp.Body)
+		}
+		if cglpath != tt.wantCGLPath {
+			t.Errorf("%s: deferred cgl request path = %q, want %q", tt.name, cglpath, tt.wantCGLPath)
+		}
+		body := struct {
+			PDQResponse
+			Code string `json:"code"`
+		}{}
+		if err := json.Unmarshal([]byte(rsp.Body), &body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Sources) != 3 || body.Sources[0].Backend != tukcnst.PDQ_SERVER_TYPE_IHE_PDQM || body.Sources[0].Status != http.StatusBadGateway {
+			t.Errorf("%s: sources = %+v, want pdqm, pixm and cgl with the pdqm 502 status", tt.name, body.Sources)
+		}
+		switch tt.wantStatus {
+		case http.StatusOK:
+			if body.Merged_Patient == nil || body.Merged_Patient.NHSID != "9999999468" || body.Merged_Patient.FamilyName != "Testpatient" {
+				t.Errorf("%s: Merged_Patient = %+v, want the patient found by pixm and cgl", tt.name, body.Merged_Patient)
+			}
+			if len(body.Warnings) != 1 || body.Warnings[0].Backend != tukcnst.PDQ_SERVER_TYPE_IHE_PDQM {
+				t.Errorf("%s: warnings = %+v, want the pdqm failure", tt.name, body.Warnings)
+			}
+		default:
+			if body.Code != ERROR_CODE_UPSTREAM_ERROR {
+				t.Errorf("%s: code = %q, want %q", tt.name, body.Code, ERROR_CODE_UPSTREAM_ERROR)
+			}
+		}
+	}
+}
+
+func TestHandleRequestPDQv3Cancel(t *testing.T) {
+	var soapaction, body string
+	srv := newSOAPServer(t, pdqv3CancelAck, &soapaction, &body)
+	t.Setenv(tukcnst.ENV_PDQ_SERVER_TYPE, tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3)
+	t.Setenv(tukcnst.ENV_PDQ_SERVER_URL, srv.URL)
+	t.Setenv(tukcnst.ENV_REG_OID, "2.16.840.1.113883.2.1.3.31.2.1.1")
+	token := base64.RawURLEncoding.EncodeToString([]byte("1.2.840.114350.1.13.28.1.18.5.999^query-1"))
+	rsp, err := Handle_Request(context.Background(), events.APIGatewayProxyRequest{
+		QueryStringParameters: map[string]string{
+			tukcnst.QUERY_PARAM_CONTINUATION: token,
+			tukcnst.QUERY_PARAM_CANCEL:       "true",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %v, want %v - %s", rsp.StatusCode, http.StatusOK, rsp.Body)
+	}
+	if soapaction != tukcnst.SOAP_ACTION_PDQV3_Cancel_Request {
+		t.Errorf("SOAPAction = %q, want %q", soapaction, tukcnst.SOAP_ACTION_PDQV3_Cancel_Request)
+	}
+	if !strings.Contains(body, "extension='query-1'") {
+		t.Errorf("cancel request does not contain the query id from the continuation token - %s", body)
+	}
+	pdqrsp := PDQResponse{}
+	if err := json.Unmarshal([]byte(rsp.Body), &pdqrsp); err != nil {
+		t.Fatal(err)
+	}
+	if pdqrsp.Count != 0 || pdqrsp.Patients != nil {
+		t.Errorf("cancel response returned patients - %s", rsp.Body)
+	}
+}
+
+func TestGetErrorResponse(t *testing.T) {
+	ackerr := &tukpdq.AckError{Code: "AE", Detail: "Query rejected"}
+	faulterr := &tukpdq.SOAPFaultError{Code: "S:Receiver", Reason: "Internal Error"}
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"not found", fmt.Errorf("%w matching 9999999468 %s", tukpdq.ErrNotFound, tukcnst.NHS_OID_DEFAULT), http.StatusNotFound, ERROR_CODE_NOT_FOUND},
+		{"timeout", &tukpdq.TimeoutError{Err: context.DeadlineExceeded}, http.StatusGatewayTimeout, ERROR_CODE_UPSTREAM_TIMEOUT},
+		{"lambda deadline", fmt.Errorf("pdq request - %w", context.DeadlineExceeded), http.StatusGatewayTimeout, ERROR_CODE_UPSTREAM_TIMEOUT},
+		{"network timeout", &net.DNSError{Err: "i/o timeout", Name: "pdq.example.org", IsTimeout: true}, http.StatusGatewayTimeout, ERROR_CODE_UPSTREAM_TIMEOUT},
+		{"invalid request", fmt.Errorf("%w - no pdq server url", tukpdq.ErrInvalidRequest), http.StatusBadRequest, ERROR_CODE_INVALID_REQUEST},
+		{"ack rejected", fmt.Errorf("pdqv3 - %w", ackerr), http.StatusBadGateway, ERROR_CODE_ACK_REJECTED},
+		{"soap fault", faulterr, http.StatusBadGateway, ERROR_CODE_SOAP_FAULT},
+		{"correlation", &tukpdq.CorrelationError{Message_ID: "1", RelatesTo: "2"}, http.StatusBadGateway, ERROR_CODE_UPSTREAM_ERROR},
+		{"http status", &tukpdq.HTTPStatusError{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQM, StatusCode: http.StatusInternalServerError}, http.StatusBadGateway, ERROR_CODE_UPSTREAM_ERROR},
+		{"network error", &net.DNSError{Err: "no such host", Name: "pdq.example.org"}, http.StatusBadGateway, ERROR_CODE_UPSTREAM_ERROR},
+	}
+	for _, tt := range tests {
+		pdq := tukpdq.PDQQuery{Server_Mode: tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3}
+		status, rsp := getErrorResponse(&pdq, tt.err, "corr-1")
+		if status != tt.wantStatus || rsp.Code != tt.wantCode {
+			t.Errorf("%s: status = %v code = %s, want %v %s", tt.name, status, rsp.Code, tt.wantStatus, tt.wantCode)
+		}
+		if rsp.Message != tt.err.Error() || rsp.Backend != tukcnst.PDQ_SERVER_TYPE_IHE_PDQV3 || rsp.CorrelationID != "corr-1" {
+			t.Errorf("%s: ErrorResponse = %+v, want the error message, backend and correlation id", tt.name, rsp)
+		}
+		if (rsp.Ack != nil) != (tt.wantCode == ERROR_CODE_ACK_REJECTED) || (rsp.Fault != nil) != (tt.wantCode == ERROR_CODE_SOAP_FAULT) {
+			t.Errorf("%s: Ack = %+v Fault = %+v, want them set only for ack and fault errors", tt.name, rsp.Ack, rsp.Fault)
+		}
+	}
+}